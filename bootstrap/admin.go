@@ -0,0 +1,227 @@
+package bootstrap
+
+import (
+	"distributed/job"
+	"distributed/message"
+	"distributed/node"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const PING_TIMEOUT = 3 * time.Second
+const STATUS_TIMEOUT = 20 * time.Second
+
+type adminState struct {
+	WaitersMutex sync.Mutex
+
+	// waiters are keyed by the request id their answer carries back
+	pongWaiters   map[int64]chan time.Time
+	statusWaiters map[int64]chan map[string]job.JobStatus
+	lastRequestId int64
+}
+
+// nextRequestId numbers the requests of the bootstrap on their own, so a
+// replay asks with the ids of the recorded run. The caller holds
+// WaitersMutex.
+func (b *Bootstrap) nextRequestId() int64 {
+	b.lastRequestId++
+	return b.lastRequestId
+}
+
+func (b *Bootstrap) findWorker(idArg string) (node.NodeInfo, bool) {
+	id, err := strconv.Atoi(idArg)
+	if err != nil {
+		fmt.Printf("Wrong node id: %s\n", idArg)
+		return node.NodeInfo{}, false
+	}
+
+	b.BootstrapTableMutex.Lock()
+	defer b.BootstrapTableMutex.Unlock()
+
+	for _, val := range b.BootstrapNode.Workers {
+		if val.Id == id {
+			return val, true
+		}
+	}
+
+	fmt.Printf("There is no node %d\n", id)
+	return node.NodeInfo{}, false
+}
+
+func (b *Bootstrap) parseListNodes() {
+	b.BootstrapTableMutex.Lock()
+	defer b.BootstrapTableMutex.Unlock()
+
+	fmt.Printf("Listing %d system nodes\n", len(b.BootstrapNode.Workers))
+	for _, n := range b.BootstrapNode.Workers {
+		fmt.Printf("%d> %v\n", n.Id, n.String())
+	}
+}
+
+func (b *Bootstrap) parsePing(idArg string) {
+	worker, ok := b.findWorker(idArg)
+	if !ok {
+		return
+	}
+
+	rtt, err := b.ping(worker)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Pong from %s in %v\n", worker.String(), rtt)
+}
+
+// ping sends a Ping to the worker and waits PING_TIMEOUT for its Pong.
+func (b *Bootstrap) ping(worker node.NodeInfo) (time.Duration, error) {
+	pongChan := make(chan time.Time, 1)
+	b.WaitersMutex.Lock()
+	requestId := b.nextRequestId()
+	b.pongWaiters[requestId] = pongChan
+	b.WaitersMutex.Unlock()
+
+	defer func() {
+		b.WaitersMutex.Lock()
+		delete(b.pongWaiters, requestId)
+		b.WaitersMutex.Unlock()
+	}()
+
+	start := time.Now()
+	toSend := message.MakePingMessage(*b.BootstrapNode.GetNodeInfo(), worker)
+	toSend.RequestId = requestId
+	if !b.sendMessage(b.BootstrapNode.GetNodeInfo(), &worker, toSend) {
+		return 0, fmt.Errorf("node %s is unreachable", worker.String())
+	}
+
+	select {
+	case pongTime := <-pongChan:
+		return pongTime.Sub(start), nil
+	case <-time.After(PING_TIMEOUT):
+		return 0, fmt.Errorf("no pong from %s in %v", worker.String(), PING_TIMEOUT)
+	case <-b.ctx.Done():
+		return 0, b.ctx.Err()
+	}
+}
+
+// parseKick asks the worker to leave the system, if it can't be reached it
+// is removed and the workers are told it is dead.
+func (b *Bootstrap) parseKick(idArg string) {
+	worker, ok := b.findWorker(idArg)
+	if !ok {
+		return
+	}
+
+	toSend := message.MakeKickMessage(*b.BootstrapNode.GetNodeInfo(), worker)
+	if b.sendMessage(b.BootstrapNode.GetNodeInfo(), &worker, toSend) {
+		fmt.Printf("Node %s asked to leave\n", worker.String())
+		return
+	}
+
+	fmt.Printf("Node %s is unreachable, removing it\n", worker.String())
+	b.removeWorker(worker)
+
+	toBroadcast := message.MakeNodeDeadMessage(*b.BootstrapNode.GetNodeInfo(), worker)
+	b.systemBroadcastMessage(toBroadcast)
+}
+
+func (b *Bootstrap) parseStatus() {
+	statusChan := make(chan map[string]job.JobStatus, 1)
+	b.WaitersMutex.Lock()
+	requestId := b.nextRequestId()
+	b.statusWaiters[requestId] = statusChan
+	b.WaitersMutex.Unlock()
+
+	defer func() {
+		b.WaitersMutex.Lock()
+		delete(b.statusWaiters, requestId)
+		b.WaitersMutex.Unlock()
+	}()
+
+	b.BootstrapTableMutex.Lock()
+	workers := append([]node.NodeInfo{}, b.BootstrapNode.Workers...)
+	b.BootstrapTableMutex.Unlock()
+
+	asked := false
+	for _, worker := range workers {
+		toSend := message.MakeSystemStatusRequestMessage(*b.BootstrapNode.GetNodeInfo(), worker)
+		toSend.RequestId = requestId
+		if b.sendMessage(b.BootstrapNode.GetNodeInfo(), &worker, toSend) {
+			fmt.Printf("Asking %s for the job status\n", worker.String())
+			asked = true
+			break
+		}
+	}
+	if !asked {
+		fmt.Println("No node to ask for the job status")
+		return
+	}
+
+	select {
+	case jobStatusMap := <-statusChan:
+		if len(jobStatusMap) == 0 {
+			fmt.Println("No jobs are running")
+		}
+		for _, jobstat := range jobStatusMap {
+			fmt.Println(jobstat.Report())
+		}
+	case <-time.After(STATUS_TIMEOUT):
+		fmt.Printf("No job status in %v\n", STATUS_TIMEOUT)
+	}
+}
+
+func (b *Bootstrap) proccesPongMessage(msg message.Message) {
+	b.WaitersMutex.Lock()
+	defer b.WaitersMutex.Unlock()
+
+	if pongChan, ok := b.pongWaiters[msg.RequestId]; ok {
+		pongChan <- time.Now()
+		delete(b.pongWaiters, msg.RequestId)
+	}
+}
+
+func (b *Bootstrap) proccesSystemStatusMessage(msg message.Message) {
+	jobStatusMap, err := message.Payload[map[string]job.JobStatus](msg)
+	if err != nil {
+		b.LogErrorChan <- err.Error()
+		return
+	}
+
+	b.WaitersMutex.Lock()
+	defer b.WaitersMutex.Unlock()
+
+	if statusChan, ok := b.statusWaiters[msg.RequestId]; ok {
+		statusChan <- jobStatusMap
+		delete(b.statusWaiters, msg.RequestId)
+	}
+}
+
+func (b *Bootstrap) proccesUpdatedNodeMessage(msg message.Message) {
+	updated, err := message.Payload[node.NodeInfo](msg)
+	if err != nil {
+		b.LogErrorChan <- err.Error()
+		return
+	}
+
+	b.BootstrapTableMutex.Lock()
+	defer b.BootstrapTableMutex.Unlock()
+
+	for ind, val := range b.BootstrapNode.Workers {
+		if val.GetFullAddress() == updated.GetFullAddress() {
+			b.BootstrapNode.Workers[ind] = updated
+			b.saveState()
+			return
+		}
+	}
+}
+
+// Workers returns the workers the bootstrap knows of.
+func (b *Bootstrap) Workers() []node.NodeInfo {
+	b.BootstrapTableMutex.Lock()
+	defer b.BootstrapTableMutex.Unlock()
+
+	workers := make([]node.NodeInfo, len(b.BootstrapNode.Workers))
+	copy(workers, b.BootstrapNode.Workers)
+	return workers
+}
@@ -2,200 +2,447 @@ package bootstrap
 
 import (
 	"bufio"
+	"context"
 	chanfile "distributed/chainfile"
+	"distributed/job"
 	"distributed/message"
 	"distributed/node"
+	"distributed/trace"
+	"distributed/transport"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 )
 
-func check(e error, addition string) {
+func (b *Bootstrap) check(e error, addition string) {
 	if e != nil {
 		// fmt.Println(e)
-		LogErrorChan <- e.Error() + addition
+		b.LogErrorChan <- e.Error() + addition
 	}
 }
 
-var LogFileChan chan string
-var LogErrorChan chan string
+// Bootstrap lets workers into the system and keeps the list of them. It
+// owns all of its state, so it can run in one process with workers.
+type Bootstrap struct {
+	LogFileChan  chan string
+	LogErrorChan chan string
 
-var BootstrapNode node.Bootstrap
+	BootstrapNode node.Bootstrap
 
-var EnterenceChannel chan int
+	EnterenceChannel chan int
 
-var BootstrapTableMutex sync.Mutex
+	LeaseMutex   sync.Mutex
+	currentLease *entryLease
 
-var ListenPortListenChan chan int32
-var CommandPortListenChan chan int32
+	BootstrapTableMutex sync.Mutex
 
-func RunBootstrap(ipAddres string, port int, FILE_SEPARATOR string, listenToCli bool) {
+	StateFilePath string
 
-	BootstrapNode = node.Bootstrap{IpAddress: ipAddres, Port: port, Workers: make([]node.NodeInfo, 0, 10)}
+	ListenPortListenChan  chan int32
+	CommandPortListenChan chan int32
 
-	EnterenceChannel = make(chan int, 1)
-	EnterenceChannel <- 1
+	// Start uses TCP when no transport was set
+	Transport transport.Transport
+	// every message the bootstrap recives and sends is written to it when set
+	Recorder *trace.Recorder
+	Clock    *message.Clock
+	// replayed messages are handled one at a time
+	replaying bool
 
-	LogFile, err := os.Create(fmt.Sprintf("files%soutput%sbootstrapLog.log", FILE_SEPARATOR, FILE_SEPARATOR))
+	logPath   string
+	errorPath string
+	logFile   *os.File
+	errorFile *os.File
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	stopped  chan struct{}
+
+	adminState
+}
+
+// ENTRY_LEASE_TIMEOUT is how long a hailing node may hold the entrance
+// before its Join must arrive.
+const ENTRY_LEASE_TIMEOUT = 10 * time.Second
+
+type entryLease struct {
+	holder node.NodeInfo
+	timer  *time.Timer
+}
+
+// Config is what RunBootstrap starts a bootstrap with, the flags of the
+// command line.
+type Config struct {
+	IpAddress     string
+	Port          int
+	FileSeparator string
+	ListenToCli   bool
+	// TCP when nil
+	Transport transport.Transport
+	// records nothing when nil
+	Recorder     *trace.Recorder
+	VectorClocks bool
+}
+
+// RunBootstrap starts a bootstrap and serves its command line until it
+// quits.
+func RunBootstrap(config Config) {
+	b, err := NewBootstrap(config.IpAddress, config.Port, config.FileSeparator)
 	if err != nil {
-		fmt.Printf(err.Error(), "LogFile")
+		fmt.Println(err)
 		return
 	}
+	b.Transport = config.Transport
+	b.Recorder = config.Recorder
+	b.Clock = message.NewClock(b.BootstrapNode.GetFullAddress(), config.VectorClocks)
 
-	ErrorFile, err := os.Create(fmt.Sprintf("files%serror%sbootstrapError.log", FILE_SEPARATOR, FILE_SEPARATOR))
-	if err != nil {
-		fmt.Printf(err.Error(), "LogFile")
+	if err := b.Start(context.Background()); err != nil {
+		fmt.Println(err)
 		return
 	}
 
-	LogFileChan = make(chan string, 15)
-	LogErrorChan = make(chan string, 15)
+	if config.ListenToCli {
+		b.listenCommand(b.CommandPortListenChan)
+	} else {
+		select {
+		case <-b.CommandPortListenChan:
+		case <-b.stopped:
+		}
+	}
+}
+
+func NewBootstrap(ipAddres string, port int, FILE_SEPARATOR string) (*Bootstrap, error) {
+	b := &Bootstrap{}
 
-	ListenPortListenChan = make(chan int32)
-	CommandPortListenChan = make(chan int32)
+	b.BootstrapNode = node.Bootstrap{IpAddress: ipAddres, Port: port, Workers: make([]node.NodeInfo, 0, 10)}
 
-	WritenFile := chanfile.ChanFile{File: LogFile, InputChan: LogFileChan}
-	ErrorWritenFile := chanfile.ChanFile{File: ErrorFile, InputChan: LogErrorChan}
+	b.EnterenceChannel = make(chan int, 1)
+	b.EnterenceChannel <- 1
 
-	go ErrorWritenFile.WriteFileFromChan()
-	go WritenFile.WriteFileFromChan()
+	// opened by setUp, replay writes its own logs
+	b.logPath = fmt.Sprintf("files%soutput%sbootstrapLog.log", FILE_SEPARATOR, FILE_SEPARATOR)
+	b.errorPath = fmt.Sprintf("files%serror%sbootstrapError.log", FILE_SEPARATOR, FILE_SEPARATOR)
 
-	LogFileChan <- "Bootstrap is running"
+	b.LogFileChan = make(chan string, 15)
+	b.LogErrorChan = make(chan string, 15)
 
-	go listenOnPort(ListenPortListenChan)
+	b.ListenPortListenChan = make(chan int32, 1)
+	b.CommandPortListenChan = make(chan int32, 1)
 
-	if listenToCli {
-		listenCommand(CommandPortListenChan)
-	} else {
-		<-CommandPortListenChan
+	b.pongWaiters = make(map[int64]chan time.Time)
+	b.statusWaiters = make(map[int64]chan map[string]job.JobStatus)
+	b.stopped = make(chan struct{})
+
+	b.StateFilePath = fmt.Sprintf("files%sbootstrapState.json", FILE_SEPARATOR)
+	b.Clock = message.NewClock(b.BootstrapNode.GetFullAddress(), false)
+
+	return b, nil
+}
+
+// SetDir puts the files of the bootstrap under dir instead of the working
+// directory.
+func (b *Bootstrap) SetDir(dir string) {
+	b.logPath = filepath.Join(dir, b.logPath)
+	b.errorPath = filepath.Join(dir, b.errorPath)
+	b.StateFilePath = filepath.Join(dir, b.StateFilePath)
+}
+
+// Start opens the listener and loads the saved worker list.
+func (b *Bootstrap) Start(ctx context.Context) error {
+	if err := b.setUp(ctx); err != nil {
+		return err
 	}
+
+	b.LogFileChan <- "Bootstrap is running"
+
+	server, err := b.Transport.Listen(b.BootstrapNode.GetFullAddress(), b.handleFrame, func(conn net.Conn, err error) {
+		b.LogErrorChan <- fmt.Sprintf("Closing connection from %s: %v", conn.RemoteAddr(), err)
+	})
+	if err != nil {
+		b.cancel()
+		close(b.stopped)
+		return err
+	}
+	go b.listenOnPort(server, b.ListenPortListenChan)
+
+	// the saved workers are pinged, their pongs need the listener
+	b.loadState()
+
+	return nil
 }
 
-func listenOnPort(listenChan chan int32) {
-	laddr, err := net.ResolveTCPAddr("tcp", BootstrapNode.GetFullAddress())
+// setUp starts the log writers and the transport, it is shared by Start and
+// Replay.
+func (b *Bootstrap) setUp(ctx context.Context) error {
+	var err error
+	b.logFile, err = os.Create(b.logPath)
 	if err != nil {
-		fmt.Println(err)
-		check(err, "ResolveTCPAddr")
-		return
+		return err
 	}
-	ln, err := net.ListenTCP("tcp", laddr)
+	b.errorFile, err = os.Create(b.errorPath)
 	if err != nil {
-		fmt.Println(err)
-		check(err, "ResolveTCPAddr")
-		return
+		b.logFile.Close()
+		return err
 	}
-	ln.SetDeadline(time.Now().Add(10 * time.Second))
 
-	for {
-		select {
-		case val := <-listenChan:
-			fmt.Println(val)
-			ln.Close()
-			return
-		default:
-			inMsg, err := ln.Accept()
+	b.ctx, b.cancel = context.WithCancel(ctx)
 
-			if err != nil {
-				if err, ok := err.(net.Error); ok {
-					check(err, "(net.Error)")
+	WritenFile := chanfile.ChanFile{File: b.logFile, InputChan: b.LogFileChan, Clock: b.Clock}
+	ErrorWritenFile := chanfile.ChanFile{File: b.errorFile, InputChan: b.LogErrorChan, Clock: b.Clock}
 
-					ln.SetDeadline(time.Now().Add(10 * time.Second))
-				}
-			} else {
-				var msgStruct message.Message
-				json.NewDecoder(inMsg).Decode(&msgStruct)
-				processRecivedMessage(msgStruct)
+	go ErrorWritenFile.WriteFileFromChan()
+	go WritenFile.WriteFileFromChan()
 
-				inMsg.Close()
-			}
+	// loading pings the saved workers
+	if b.Transport == nil {
+		b.Transport = transport.NewTCPTransport()
+	}
+	return nil
+}
+
+// Stop closes the listener and every open connection.
+func (b *Bootstrap) Stop() {
+	b.stopOnce.Do(func() {
+		b.cancel()
+		<-b.stopped
+	})
+}
+
+func (b *Bootstrap) listenOnPort(server *transport.Server, listenChan chan int32) {
+	go func() {
+		select {
+		case val := <-listenChan:
+			fmt.Println(val)
+		case <-b.ctx.Done():
 		}
-		time.Sleep(time.Millisecond * 200)
+		b.cancel()
+		server.Close()
+		b.Transport.Close()
+		b.Recorder.Close()
+		close(b.stopped)
+	}()
+
+	if err := server.Serve(); err != nil {
+		b.check(err, "Serve")
 	}
 }
 
-func processRecivedMessage(msgStruct message.Message) {
+func (b *Bootstrap) handleFrame(conn net.Conn, data []byte) {
+	var msgStruct message.Message
+	if err := json.Unmarshal(data, &msgStruct); err != nil {
+		b.LogErrorChan <- fmt.Sprintf("Dropping bad message from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	b.processRecivedMessage(msgStruct)
+}
+
+func (b *Bootstrap) processRecivedMessage(msgStruct message.Message) {
+	b.Clock.Witness(msgStruct)
+	b.check(b.Recorder.Record(trace.In, "", msgStruct), "record")
 
-	LogFileChan <- "Finally Recived " + msgStruct.Log()
+	b.LogFileChan <- "Finally Recived " + msgStruct.Log()
 
 	switch msgStruct.MessageType {
 	case message.Hail:
-		go proccesHailMessage(msgStruct)
+		b.handle(b.proccesHailMessage, msgStruct)
 	case message.Join:
-		go proccesJoinMessage(msgStruct)
+		b.handle(b.proccesJoinMessage, msgStruct)
 	case message.Leave:
-		go proccesLeaveMessage(msgStruct)
+		b.handle(b.proccesLeaveMessage, msgStruct)
+	case message.NodeDead:
+		b.handle(b.proccesNodeDeadMessage, msgStruct)
+	case message.UpdatedNode:
+		b.handle(b.proccesUpdatedNodeMessage, msgStruct)
+	case message.Pong:
+		b.handle(b.proccesPongMessage, msgStruct)
+	case message.SystemStatus:
+		b.handle(b.proccesSystemStatusMessage, msgStruct)
 	}
 
 }
 
-func proccesHailMessage(msg message.Message) {
+func (b *Bootstrap) proccesHailMessage(msg message.Message) {
+
+	<-b.EnterenceChannel // ulazimo u kriticnu sekciju
+	b.grantLease(msg.OriginalSender)
 
-	<-EnterenceChannel // ulazimo u kriticnu sekciju
 	var toSend *message.Message
-	fmt.Println(len(BootstrapNode.Workers))
-	if len(BootstrapNode.Workers) == 0 {
-		toSend = message.MakeContactMessage(*BootstrapNode.GetNodeInfo(), msg.GetSender(), node.NodeInfo{Id: -1, IpAddress: "rafhost", Port: -10})
+	fmt.Println(len(b.Workers()))
+	if contact, ok := b.findLiveContact(); !ok {
+		toSend = message.MakeContactMessage(*b.BootstrapNode.GetNodeInfo(), msg.GetSender(), node.NodeInfo{Id: -1, IpAddress: "rafhost", Port: -10})
 	} else {
-		toSend = message.MakeContactMessage(*BootstrapNode.GetNodeInfo(), msg.GetSender(), BootstrapNode.Workers[len(BootstrapNode.Workers)-1])
+		toSend = message.MakeContactMessage(*b.BootstrapNode.GetNodeInfo(), msg.GetSender(), contact)
 	}
 	fmt.Println(toSend.Message)
-	sendMessage(BootstrapNode.GetNodeInfo(), &msg.OriginalSender, toSend)
+	b.sendMessage(b.BootstrapNode.GetNodeInfo(), &msg.OriginalSender, toSend)
 }
 
-func proccesJoinMessage(msg message.Message) {
-	BootstrapNode.Workers = append(BootstrapNode.Workers, msg.OriginalSender)
-	fmt.Println(msg.GetSender())
-	EnterenceChannel <- 1 // izlazimo iz kriticne sekcije
+// proccesJoinMessage takes the id of a welcomed worker, the worker enters
+// the system only once it was taken. An id given out twice, to a node whose
+// entry lease expired and to the next hailer, is refused to the second to
+// join and that node hails again.
+func (b *Bootstrap) proccesJoinMessage(msg message.Message) {
+	b.releaseLease(msg.OriginalSender) // izlazimo iz kriticne sekcije
+
+	accepted := b.addWorker(msg.OriginalSender)
+	toSend := message.MakeJoinResponseMessage(*b.BootstrapNode.GetNodeInfo(), msg.OriginalSender, accepted)
+	b.sendMessage(b.BootstrapNode.GetNodeInfo(), &msg.OriginalSender, toSend)
+}
+
+func (b *Bootstrap) addWorker(joined node.NodeInfo) bool {
+	b.BootstrapTableMutex.Lock()
+	defer b.BootstrapTableMutex.Unlock()
+
+	for ind, val := range b.BootstrapNode.Workers {
+		if val.GetFullAddress() == joined.GetFullAddress() {
+			b.BootstrapNode.Workers[ind] = joined
+			b.saveState()
+			return true
+		}
+		if val.Id == joined.Id {
+			b.LogErrorChan <- fmt.Sprintf("Late join of %v, its id is already taken by %v", joined.String(), val.String())
+			return false
+		}
+	}
+
+	// the youngest, removals after its id was given compacted the others
+	joined.Id = len(b.BootstrapNode.Workers)
+	b.BootstrapNode.Workers = append(b.BootstrapNode.Workers, joined)
+	fmt.Println(joined)
+
+	b.saveState()
+	return true
+}
+
+func (b *Bootstrap) grantLease(holder node.NodeInfo) {
+	b.LeaseMutex.Lock()
+	defer b.LeaseMutex.Unlock()
+
+	lease := &entryLease{holder: holder}
+	lease.timer = time.AfterFunc(ENTRY_LEASE_TIMEOUT, func() {
+		b.expireLease(lease)
+	})
+	b.currentLease = lease
+}
+
+// expireLease gives the entrance to the next hailer when the holder never
+// joined. No id is kept for the holder before its Join, a late Join is
+// refused when its id was taken meanwhile.
+func (b *Bootstrap) expireLease(lease *entryLease) {
+	b.LeaseMutex.Lock()
+	defer b.LeaseMutex.Unlock()
+
+	if b.currentLease != lease {
+		return
+	}
+	b.currentLease = nil
+
+	b.LogErrorChan <- fmt.Sprintf("Entry lease of %v expired, letting the next node in", lease.holder.String())
+	b.EnterenceChannel <- 1
 }
 
-func proccesLeaveMessage(msg message.Message) {
-	BootstrapTableMutex.Lock()
-	defer BootstrapTableMutex.Unlock()
+func (b *Bootstrap) releaseLease(holder node.NodeInfo) {
+	b.LeaseMutex.Lock()
+	defer b.LeaseMutex.Unlock()
+
+	if b.currentLease == nil || b.currentLease.holder.GetFullAddress() != holder.GetFullAddress() {
+		return
+	}
+	b.currentLease.timer.Stop()
+	b.currentLease = nil
+
+	b.EnterenceChannel <- 1
+}
+
+func (b *Bootstrap) proccesLeaveMessage(msg message.Message) {
+	if !b.removeWorker(msg.OriginalSender) {
+		b.LogErrorChan <- fmt.Sprintf("Tried to remove node from the system: %v", msg.OriginalSender.String())
+	}
+}
+
+func (b *Bootstrap) proccesNodeDeadMessage(msg message.Message) {
+	deadNode, err := message.Payload[node.NodeInfo](msg)
+	if err != nil {
+		b.LogErrorChan <- err.Error()
+		return
+	}
+
+	b.LogFileChan <- fmt.Sprintf("Node %d reported %s dead", msg.OriginalSender.Id, deadNode.String())
+
+	if !b.removeWorker(deadNode) {
+		b.LogErrorChan <- fmt.Sprintf("Dead node already removed from the system: %v", deadNode.String())
+	}
+}
+
+func (b *Bootstrap) removeWorker(toRemove node.NodeInfo) bool {
+	b.BootstrapTableMutex.Lock()
+	defer b.BootstrapTableMutex.Unlock()
 
 	nodeIndex := -1
-	for ind, val := range BootstrapNode.Workers {
-		if val.Id == msg.OriginalSender.Id {
+	for ind, val := range b.BootstrapNode.Workers {
+		if val.GetFullAddress() == toRemove.GetFullAddress() {
 			nodeIndex = ind
 			break
 		}
 	}
 
 	if nodeIndex == -1 {
-		LogErrorChan <- fmt.Sprintf("Tried to remove node from the system: %v", msg.OriginalSender.String())
-		return
+		return false
 	}
 
-	copy(BootstrapNode.Workers[nodeIndex:], BootstrapNode.Workers[nodeIndex+1:])
-	BootstrapNode.Workers = BootstrapNode.Workers[:len(BootstrapNode.Workers)-1]
+	removedId := b.BootstrapNode.Workers[nodeIndex].Id
+
+	copy(b.BootstrapNode.Workers[nodeIndex:], b.BootstrapNode.Workers[nodeIndex+1:])
+	b.BootstrapNode.Workers = b.BootstrapNode.Workers[:len(b.BootstrapNode.Workers)-1]
+
+	// workers compact their ids the same way when a node is removed
+	for ind := range b.BootstrapNode.Workers {
+		if b.BootstrapNode.Workers[ind].Id > removedId {
+			b.BootstrapNode.Workers[ind].Id--
+		}
+	}
+
+	b.saveState()
+
+	return true
 }
 
-func sendMessage(sender, reciver *node.NodeInfo, msg *message.Message) bool {
-	connOut, err := net.DialTimeout("tcp", reciver.GetFullAddress(), time.Duration(1)*time.Second)
+func (b *Bootstrap) sendMessage(sender, reciver *node.NodeInfo, msg *message.Message) bool {
+	// broadcasts send one message to several workers at once
+	stamped := *msg
+	b.Clock.Stamp(&stamped)
+	msg = &stamped
+
+	b.check(b.Recorder.Record(trace.Out, reciver.GetFullAddress(), *msg), "record")
+
+	data, err := json.Marshal(msg)
 	if err != nil {
-		if _, ok := err.(net.Error); ok {
-			// fmt.Println("Error received while connecting to ", reciver.NodeId)
-			check(err, "sendMessage")
-			return false
-		}
-	} else {
-		json.NewEncoder(connOut).Encode(&msg)
-		connOut.Close()
+		b.check(err, "sendMessage")
+		return false
+	}
+
+	// the bootstrap talks to workers rarely, no connection is kept
+	if err := b.Transport.Send(reciver.GetFullAddress(), data, false); err != nil {
+		b.check(err, "sendMessage")
+		return false
 	}
 
 	return true
 }
 
-func systemBroadcastMessage(msg *message.Message) {
-	for _, v := range BootstrapNode.Workers {
-		sendMessage(BootstrapNode.GetNodeInfo(), &v, msg)
+func (b *Bootstrap) systemBroadcastMessage(msg *message.Message) {
+	for _, v := range b.BootstrapNode.Workers {
+		b.sendMessage(b.BootstrapNode.GetNodeInfo(), &v, msg)
 	}
 }
 
-func parseCommand(commandArg string) bool {
+func (b *Bootstrap) parseCommand(commandArg string) bool {
 	if len(commandArg) == 0 {
 		return true
 	}
@@ -204,21 +451,35 @@ func parseCommand(commandArg string) bool {
 	command := command_arr[0]
 	if strings.EqualFold(command, "quit") {
 		fmt.Println("Quitting...")
-		ListenPortListenChan <- 1
+		b.ListenPortListenChan <- 1
 		time.Sleep(time.Second)
 		return false
 	} else if strings.EqualFold(command, "purge") {
-		toSend := message.MakePurgeMessage(*BootstrapNode.GetNodeInfo())
-		go systemBroadcastMessage(toSend)
-		ListenPortListenChan <- 1
+		toSend := message.MakePurgeMessage(*b.BootstrapNode.GetNodeInfo())
+		// sent before the listener closes, the process exits right after
+		b.systemBroadcastMessage(toSend)
+		b.removeState()
+		b.ListenPortListenChan <- 1
 		return false
+	} else if strings.EqualFold(command, "nodes") {
+		b.parseListNodes()
+		return true
+	} else if strings.EqualFold(command, "ping") && len(command_arr) == 2 {
+		b.parsePing(command_arr[1])
+		return true
+	} else if strings.EqualFold(command, "kick") && len(command_arr) == 2 {
+		b.parseKick(command_arr[1])
+		return true
+	} else if strings.EqualFold(command, "status") {
+		b.parseStatus()
+		return true
 	} else {
 		fmt.Printf("Unknown command: %s\n", command)
 		return true
 	}
 }
 
-func listenCommand(listenChan chan int32) {
+func (b *Bootstrap) listenCommand(listenChan chan int32) {
 	fmt.Println("Simple Shell")
 	fmt.Println("---------------------")
 
@@ -237,7 +498,7 @@ func listenCommand(listenChan chan int32) {
 			if err != nil {
 				// close channel just to inform others
 				close(in)
-				LogErrorChan <- fmt.Sprintln("Error in read string", err)
+				b.LogErrorChan <- fmt.Sprintln("Error in read string", err)
 			}
 			text = strings.Replace(text, "\n", "", -1)
 			in <- text
@@ -251,7 +512,7 @@ func listenCommand(listenChan chan int32) {
 		case <-listenChan:
 			return
 		case text := <-input:
-			if !parseCommand(text) {
+			if !b.parseCommand(text) {
 				return
 			}
 			wainchanel <- " "
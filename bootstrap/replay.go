@@ -0,0 +1,62 @@
+package bootstrap
+
+import (
+	"context"
+	"distributed/message"
+	"distributed/trace"
+	"distributed/transport"
+	"fmt"
+	"time"
+)
+
+// ReplayBootstrap makes the bootstrap that recorded the trace and feeds it
+// the trace again.
+func ReplayBootstrap(tracePath string, FILE_SEPARATOR string, step bool, recorder *trace.Recorder) {
+	records, ipAddres, port, err := trace.LoadNode(tracePath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	b, err := NewBootstrap(ipAddres, port, FILE_SEPARATOR)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	b.Recorder = recorder
+
+	if err := b.Replay(context.Background(), records, step); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// handle runs the handler of a recived message in a goroutine of its own,
+// in a replay it waits for it so the messages are handled in order.
+func (b *Bootstrap) handle(handler func(message.Message), msgStruct message.Message) {
+	if !trace.Handle(b.replaying, handler, msgStruct) {
+		b.LogErrorChan <- fmt.Sprintf("Replaying on while %s is still handled", msgStruct.Log())
+	}
+}
+
+// Replay feeds the recived messages of a trace to the bootstrap in the order
+// they were recived. Nothing is sent, the saved worker list is not loaded
+// and the logs and the worker list go next to the recorded ones. With step
+// set it waits for enter before every message.
+func (b *Bootstrap) Replay(ctx context.Context, records []trace.Record, step bool) error {
+	b.replaying = true
+	b.logPath = trace.ReplayPath(b.logPath)
+	b.errorPath = trace.ReplayPath(b.errorPath)
+	b.StateFilePath = trace.ReplayPath(b.StateFilePath)
+	b.Transport = transport.NewDiscardTransport()
+	if err := b.setUp(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		time.Sleep(trace.REPLAY_SETTLE)
+		b.cancel()
+		b.Recorder.Close()
+		close(b.stopped)
+	}()
+
+	return trace.Play(records, step, b.processRecivedMessage)
+}
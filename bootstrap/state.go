@@ -0,0 +1,95 @@
+package bootstrap
+
+import (
+	"distributed/node"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// saveState writes the worker table to disk, the caller holds
+// BootstrapTableMutex.
+func (b *Bootstrap) saveState() {
+	data, err := json.Marshal(b.BootstrapNode.Workers)
+	if err != nil {
+		b.check(err, "saveState")
+		return
+	}
+
+	tmpPath := b.StateFilePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		b.check(err, "saveState")
+		return
+	}
+	b.check(os.Rename(tmpPath, b.StateFilePath), "saveState")
+}
+
+// loadState reloads the worker table saved by a previous run and drops the
+// workers that no longer answer.
+func (b *Bootstrap) loadState() {
+	data, err := os.ReadFile(b.StateFilePath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			b.check(err, "loadState")
+		}
+		return
+	}
+
+	var workers []node.NodeInfo
+	if err := json.Unmarshal(data, &workers); err != nil {
+		b.check(err, "loadState")
+		return
+	}
+
+	b.BootstrapTableMutex.Lock()
+	b.BootstrapNode.Workers = workers
+	b.BootstrapTableMutex.Unlock()
+
+	b.LogFileChan <- fmt.Sprintf("Loaded %d workers from %s", len(workers), b.StateFilePath)
+
+	for _, worker := range workers {
+		if !b.isAlive(worker) {
+			b.LogFileChan <- "Recorded worker is gone: " + worker.String()
+			b.removeWorker(worker)
+		}
+	}
+}
+
+func (b *Bootstrap) removeState() {
+	err := os.Remove(b.StateFilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		b.check(err, "removeState")
+	}
+}
+
+// isAlive tells if the worker answered a Ping in PING_TIMEOUT, a worker
+// that accepts the connection but does not answer is dead too.
+func (b *Bootstrap) isAlive(worker node.NodeInfo) bool {
+	_, err := b.ping(worker)
+	if err != nil {
+		b.LogErrorChan <- err.Error()
+	}
+	return err == nil
+}
+
+// findLiveContact returns the newest worker that still answers, dropping the
+// dead ones on the way.
+func (b *Bootstrap) findLiveContact() (node.NodeInfo, bool) {
+	for {
+		b.BootstrapTableMutex.Lock()
+		if len(b.BootstrapNode.Workers) == 0 {
+			b.BootstrapTableMutex.Unlock()
+			return node.NodeInfo{}, false
+		}
+		contact := b.BootstrapNode.Workers[len(b.BootstrapNode.Workers)-1]
+		b.BootstrapTableMutex.Unlock()
+
+		if b.isAlive(contact) {
+			return contact, true
+		}
+
+		b.LogFileChan <- "Contact is gone, dropping it: " + contact.String()
+		b.removeWorker(contact)
+	}
+}
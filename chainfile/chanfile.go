@@ -9,6 +9,9 @@ import (
 type ChanFile struct {
 	File      *os.File
 	InputChan chan string
+	// logical time of the node, taken when a line is written so the lines
+	// of a file never go back in time
+	Clock fmt.Stringer
 }
 
 func (ch ChanFile) String() string {
@@ -23,6 +26,9 @@ func (ch *ChanFile) WriteFileFromChan() {
 		}
 		time_string := time.Now().Format("01-02-2006 15:04:05")
 		writen := fmt.Sprintf("[%v] %s\n", time_string, msg)
+		if ch.Clock != nil {
+			writen = fmt.Sprintf("[%v] [%s] %s\n", time_string, ch.Clock.String(), msg)
+		}
 		ch.File.Write([]byte(writen))
 	}
 }
@@ -0,0 +1,76 @@
+package chanfile_test
+
+import (
+	"bufio"
+	"distributed/chainfile"
+	"distributed/message"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+var clockPattern = regexp.MustCompile(`^\[[^\]]*\] \[L(\d+)\] `)
+
+// lines logged by several goroutines are stamped in the order they are
+// written, so the logical time of a file never goes back
+func TestLinesOfAFileKeepTheirOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := message.NewClock("a", false)
+	lines := make(chan string)
+	writer := chanfile.ChanFile{File: file, InputChan: lines, Clock: clock}
+	done := make(chan struct{})
+	go func() {
+		writer.WriteFileFromChan()
+		close(done)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				var msg message.Message
+				clock.Stamp(&msg)
+				lines <- "sent"
+			}
+		}()
+	}
+	wg.Wait()
+	close(lines)
+	<-done
+	file.Close()
+
+	file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	last := int64(-1)
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		match := clockPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			t.Fatalf("line without logical time: %q", scanner.Text())
+		}
+		lamport, _ := strconv.ParseInt(match[1], 10, 64)
+		if lamport < last {
+			t.Fatalf("line %d went back from L%d to L%d", count+1, last, lamport)
+		}
+		last = lamport
+		count++
+	}
+	if count != 400 {
+		t.Fatalf("wrote %d lines, want 400", count)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"image/draw"
 	"image/png"
 	"os"
+	"strings"
 )
 
 type Job struct {
@@ -18,7 +19,7 @@ type Job struct {
 	Height     int                `json:"height"`
 	MainPoints []structures.Point `json:"mainPoints"`
 	Points     []structures.Point `json:"allPoints"`
-	Working    bool               `json:"-"`
+	Working    bool               `json:"working,omitempty"`
 }
 
 func (job *Job) Log() string {
@@ -61,6 +62,16 @@ func (js *JobStatus) Log() string {
 	return fmt.Sprintf("Job Status %s, with %d gen points and %d working nodes <> %v", js.Name, js.PointsGenerated, js.WorkingNodes, js.PointsPerNodes)
 }
 
+func (js *JobStatus) Report() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Job %s with %d Working nodes has %d generated points\n", js.Name, js.WorkingNodes, js.PointsGenerated))
+	for key, val := range js.PointsPerNodes {
+		sb.WriteString(fmt.Sprintf("\t %s] %d\n", key, val))
+	}
+	sb.WriteString("-----------------------------")
+	return sb.String()
+}
+
 func (j *Job) GetJobStatus(fractalID string) *JobStatus {
 	jobStatus := new(JobStatus)
 	jobStatus.Name = j.Name
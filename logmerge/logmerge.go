@@ -0,0 +1,129 @@
+// Package logmerge interleaves the log files of the nodes of one run by the
+// logical time their lines were written at.
+package logmerge
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a line starts with the wall time and the clock of its node, lines that do
+// not continue the line before them
+var linePattern = regexp.MustCompile(`^\[(\d\d-\d\d-\d{4} \d\d:\d\d:\d\d)\] \[L(\d+)[^\]]*\]`)
+
+const TIME_LAYOUT = "01-02-2006 15:04:05"
+
+type entry struct {
+	lamport int64
+	time    time.Time
+	lines   []string
+}
+
+type logFile struct {
+	name    string
+	entries []entry
+	next    int
+}
+
+func readLog(path string) (*logFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	log := &logFile{name: filepath.Base(path)}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		match := linePattern.FindStringSubmatch(line)
+		if match == nil {
+			if len(log.entries) == 0 {
+				return nil, fmt.Errorf("%s:%d: no logical time, was the node run before clocks were logged?", path, lineNo)
+			}
+			last := &log.entries[len(log.entries)-1]
+			last.lines = append(last.lines, line)
+			continue
+		}
+
+		lamport, _ := strconv.ParseInt(match[2], 10, 64)
+		wall, _ := time.ParseInLocation(TIME_LAYOUT, match[1], time.Local)
+		log.entries = append(log.entries, entry{lamport: lamport, time: wall, lines: []string{line}})
+	}
+	return log, scanner.Err()
+}
+
+// Merge writes the lines of every log prefixed with the name of its file.
+// The clock of a node never goes back, so the lines of one file stay in
+// their order and a line is written after every line with a lower Lamport
+// time. Lines of the same time are ordered by wall time, then by file.
+func Merge(paths []string, out io.Writer) error {
+	logs := make([]*logFile, 0, len(paths))
+	for _, path := range paths {
+		log, err := readLog(path)
+		if err != nil {
+			return err
+		}
+		logs = append(logs, log)
+	}
+
+	writer := bufio.NewWriter(out)
+	defer writer.Flush()
+
+	for {
+		var first *logFile
+		for _, log := range logs {
+			if log.next == len(log.entries) {
+				continue
+			}
+			if first == nil || before(log.entries[log.next], first.entries[first.next]) {
+				first = log
+			}
+		}
+		if first == nil {
+			return nil
+		}
+
+		for _, line := range first.entries[first.next].lines {
+			if _, err := fmt.Fprintf(writer, "%s %s\n", first.name, line); err != nil {
+				return err
+			}
+		}
+		first.next++
+	}
+}
+
+func before(a, b entry) bool {
+	if a.lamport != b.lamport {
+		return a.lamport < b.lamport
+	}
+	return a.time.Before(b.time)
+}
+
+// MergeDir merges every .log file of the directory. Logs written by a replay
+// repeat the run they replay, they are left out.
+func MergeDir(dir string, out io.Writer) error {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(matches))
+	for _, path := range matches {
+		if !strings.HasSuffix(path, "_replay.log") {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("there are no logs in %s", dir)
+	}
+	return Merge(paths, out)
+}
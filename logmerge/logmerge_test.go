@@ -0,0 +1,74 @@
+package logmerge_test
+
+import (
+	"bytes"
+	"distributed/logmerge"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLog(t *testing.T, dir, name string, lines ...string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeOrdersByLamportTime(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, "a.log",
+		"[01-02-2006 15:04:05] [L1] a sends",
+		"[01-02-2006 15:04:07] [L4] a recives",
+		"a recived payload",
+	)
+	writeLog(t, dir, "b.log",
+		"[01-02-2006 15:04:09] [L2] b recives",
+		"[01-02-2006 15:04:09] [L3] b sends",
+		"[01-02-2006 15:04:01] [L4] b is done",
+	)
+
+	var out bytes.Buffer
+	if err := logmerge.Merge([]string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"a.log [01-02-2006 15:04:05] [L1] a sends",
+		"b.log [01-02-2006 15:04:09] [L2] b recives",
+		"b.log [01-02-2006 15:04:09] [L3] b sends",
+		// the same time is ordered by wall time
+		"b.log [01-02-2006 15:04:01] [L4] b is done",
+		"a.log [01-02-2006 15:04:07] [L4] a recives",
+		"a.log a recived payload",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("merged:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMergeNeedsLogicalTime(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, "old.log", "[01-02-2006 15:04:05] no clock")
+
+	var out bytes.Buffer
+	if err := logmerge.Merge([]string{filepath.Join(dir, "old.log")}, &out); err == nil {
+		t.Fatal("merged a log without logical time")
+	}
+}
+
+func TestMergeDirLeavesOutReplayLogs(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, "worker.log", "[01-02-2006 15:04:05] [L1] run")
+	writeLog(t, dir, "worker_replay.log", "[01-02-2006 15:04:05] [L1] replay")
+
+	var out bytes.Buffer
+	if err := logmerge.MergeDir(dir, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(out.String()); got != "worker.log [01-02-2006 15:04:05] [L1] run" {
+		t.Fatalf("merged %q", got)
+	}
+}
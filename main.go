@@ -3,7 +3,10 @@ package main
 import (
 	"distributed/bootstrap"
 	"distributed/job"
+	"distributed/logmerge"
 	"distributed/structures"
+	"distributed/trace"
+	"distributed/transport"
 	"distributed/worker"
 	"encoding/json"
 	"flag"
@@ -34,10 +37,28 @@ func main() {
 	bootstrapIpAddressFlag := flag.String("BootstrapIpAddress", "", "bootstrap ip address")
 	bootstrapPortFlag := flag.String("BootstrapPort", "", "bootstrap port")
 	listenCommandFlag := flag.Bool("Listener", false, "Node listen to CLI")
+	queryTimeoutFlag := flag.Duration("QueryTimeout", worker.DEFAULT_QUERY_TIMEOUT, "how long status and result wait for other nodes")
+	socketDirFlag := flag.String("SocketDir", "", "talk over unix sockets in this directory instead of TCP")
+	seedFlag := flag.Int64("Seed", 0, "seed of the random numbers, 0 seeds from the clock")
+	recordFileFlag := flag.String("RecordFile", "", "record every recived and sent message to this file as JSON lines")
+	replayFlag := flag.String("Replay", "", "feed the recived messages of a recorded trace to the node instead of joining")
+	replayStepFlag := flag.Bool("ReplayStep", false, "wait for enter before every replayed message")
+	vectorClocksFlag := flag.Bool("VectorClocks", false, "send vector clocks with every message and log them")
+	treeBroadcastFlag := flag.Bool("TreeBroadcast", false, "send broadcasts of workers down a spanning tree of the ring instead of flooding them")
+	mergeLogsFlag := flag.String("MergeLogs", "", "print the logs of this directory interleaved by logical time and exit")
 
 	flag.Parse()
 
-	rand.Seed(time.Now().UnixNano())
+	if len(*mergeLogsFlag) > 0 {
+		check(logmerge.MergeDir(*mergeLogsFlag, os.Stdout), "MergeLogs")
+		return
+	}
+
+	seed := *seedFlag
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rand.Seed(seed)
 
 	var bootMap map[string]interface{}
 	dat, err := os.ReadFile(*systemFile)
@@ -68,8 +89,38 @@ func main() {
 	fmt.Println(bootMap)
 	fmt.Println(ipAddress, bootstrapIpAddress, port, bootstrapPort)
 
+	peerTransport := transport.NewTCPTransport()
+	if len(*socketDirFlag) > 0 {
+		peerTransport = transport.NewUnixTransport(*socketDirFlag)
+	}
+
+	var recorder *trace.Recorder
+	if len(*recordFileFlag) > 0 && *recordFileFlag == *replayFlag {
+		fmt.Println("Recording over the replayed trace would erase it")
+		return
+	}
+	if len(*recordFileFlag) > 0 {
+		recorder, err = trace.NewRecorder(*recordFileFlag, fmt.Sprintf("%s:%d", ipAddress, port), seed)
+		if err != nil {
+			check(err, "RecordFile")
+			return
+		}
+	}
+
 	if *isBootstrap {
-		bootstrap.RunBootstrap(ipAddress, port, *FILE_SEPARATOR, *listenCommandFlag)
+		if len(*replayFlag) > 0 {
+			bootstrap.ReplayBootstrap(*replayFlag, *FILE_SEPARATOR, *replayStepFlag, recorder)
+			return
+		}
+		bootstrap.RunBootstrap(bootstrap.Config{
+			IpAddress:     ipAddress,
+			Port:          port,
+			FileSeparator: *FILE_SEPARATOR,
+			ListenToCli:   *listenCommandFlag,
+			Transport:     peerTransport,
+			Recorder:      recorder,
+			VectorClocks:  *vectorClocksFlag,
+		})
 	} else {
 
 		if len(*bootstrapIpAddressFlag) > 0 {
@@ -92,7 +143,24 @@ func main() {
 		// mapstructure.Decode(jobs_interface, JobList)
 
 		fmt.Printf("%T %v\n", jobs_interface, jobs_interface)
-		worker.RunWorker(ipAddress, port, bootstrapIpAddress, bootstrapPort, JobList, *FILE_SEPARATOR, *listenCommandFlag)
+		if len(*replayFlag) > 0 {
+			worker.ReplayWorker(*replayFlag, bootstrapIpAddress, bootstrapPort, JobList, *FILE_SEPARATOR, *replayStepFlag, recorder)
+			return
+		}
+		worker.RunWorker(worker.Config{
+			IpAddress:          ipAddress,
+			Port:               port,
+			BootstrapIpAddress: bootstrapIpAddress,
+			BootstrapPort:      bootstrapPort,
+			Jobs:               JobList,
+			FileSeparator:      *FILE_SEPARATOR,
+			ListenToCli:        *listenCommandFlag,
+			QueryTimeout:       *queryTimeoutFlag,
+			Transport:          peerTransport,
+			Recorder:           recorder,
+			VectorClocks:       *vectorClocksFlag,
+			TreeBroadcast:      *treeBroadcastFlag,
+		})
 	}
 }
 
@@ -0,0 +1,90 @@
+package message
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Clock is the logical clock of one node. Sending and reciving a message
+// are its events, every message carries the Lamport time it was sent at and
+// the vector time too when vectors are on. Vectors are keyed by the full
+// address of a node, ids change when the system is compacted.
+type Clock struct {
+	mutex   sync.Mutex
+	node    string
+	lamport int64
+	vector  map[string]int64
+}
+
+func NewClock(node string, vectors bool) *Clock {
+	clock := &Clock{node: node}
+	if vectors {
+		clock.vector = make(map[string]int64)
+	}
+	return clock
+}
+
+// Stamp ticks the clock for sending the message and writes the time into
+// it.
+func (c *Clock) Stamp(msg *Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lamport++
+	msg.Clock = c.lamport
+	msg.Vector = nil
+
+	if c.vector != nil {
+		c.vector[c.node]++
+		msg.Vector = make(map[string]int64, len(c.vector))
+		for key, val := range c.vector {
+			msg.Vector[key] = val
+		}
+	}
+}
+
+// Witness ticks the clock for reciving the message, past the time it was
+// sent at.
+func (c *Clock) Witness(msg Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if msg.Clock > c.lamport {
+		c.lamport = msg.Clock
+	}
+	c.lamport++
+
+	if c.vector != nil {
+		for key, val := range msg.Vector {
+			if val > c.vector[key] {
+				c.vector[key] = val
+			}
+		}
+		c.vector[c.node]++
+	}
+}
+
+// String is the time a log line is written with, L12 or L12 V{address=3 ...}
+// with vectors on.
+func (c *Clock) String() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.vector == nil {
+		return fmt.Sprintf("L%d", c.lamport)
+	}
+
+	keys := make([]string, 0, len(c.vector))
+	for key := range c.vector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, fmt.Sprintf("%s=%d", key, c.vector[key]))
+	}
+	return fmt.Sprintf("L%d V{%s}", c.lamport, strings.Join(entries, " "))
+}
@@ -0,0 +1,48 @@
+package message_test
+
+import (
+	"distributed/message"
+	"testing"
+)
+
+func TestStampTicksTheClock(t *testing.T) {
+	clock := message.NewClock("a", false)
+
+	var first, second message.Message
+	clock.Stamp(&first)
+	clock.Stamp(&second)
+
+	if first.Clock != 1 || second.Clock != 2 {
+		t.Fatalf("stamped %d and %d, want 1 and 2", first.Clock, second.Clock)
+	}
+	if first.Vector != nil {
+		t.Fatalf("stamped vector %v with vectors off", first.Vector)
+	}
+	if got := clock.String(); got != "L2" {
+		t.Fatalf("clock is %s, want L2", got)
+	}
+}
+
+func TestWitnessMovesPastTheSender(t *testing.T) {
+	sender := message.NewClock("a", true)
+	reciver := message.NewClock("b", true)
+
+	var msg message.Message
+	for i := 0; i < 5; i++ {
+		sender.Stamp(&msg)
+	}
+	reciver.Witness(msg)
+
+	if got := reciver.String(); got != "L6 V{a=5 b=1}" {
+		t.Fatalf("clock is %s, want L6 V{a=5 b=1}", got)
+	}
+
+	// an older message does not move the clock back
+	var old message.Message
+	message.NewClock("a", true).Stamp(&old)
+	reciver.Witness(old)
+
+	if got := reciver.String(); got != "L7 V{a=5 b=2}" {
+		t.Fatalf("clock is %s, want L7 V{a=5 b=2}", got)
+	}
+}
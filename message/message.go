@@ -28,6 +28,7 @@ const (
 	Contact                   MessageType = "Contact"
 	Welcome                   MessageType = "Welcome"
 	Join                      MessageType = "Join"
+	JoinResponse              MessageType = "JoinResponse"
 	Leave                     MessageType = "Leave"
 	Entered                   MessageType = "Entered"
 	ConnectionRequest         MessageType = "ConnectionRequest"
@@ -51,6 +52,24 @@ const (
 	JobStatusRequest          MessageType = "JobStatusRequest"
 	JobStatus                 MessageType = "JobStatus"
 	UpdatedNode               MessageType = "UpdatedNode"
+	Ping                      MessageType = "Ping"
+	Pong                      MessageType = "Pong"
+	CheckSuspect              MessageType = "CheckSuspect"
+	SuspectStatus             MessageType = "SuspectStatus"
+	NodeDead                  MessageType = "NodeDead"
+	HandoffPoints             MessageType = "HandoffPoints"
+	ReplicaPoints             MessageType = "ReplicaPoints"
+	Kick                      MessageType = "Kick"
+	SystemStatusRequest       MessageType = "SystemStatusRequest"
+	SystemStatus              MessageType = "SystemStatus"
+	TokenRequest              MessageType = "TokenRequest"
+	Token                     MessageType = "Token"
+	TokenHolderQuery          MessageType = "TokenHolderQuery"
+	TokenHolder               MessageType = "TokenHolder"
+	SnapshotMarker            MessageType = "SnapshotMarker"
+	SnapshotReport            MessageType = "SnapshotReport"
+	SnapshotChannel           MessageType = "SnapshotChannel"
+	Ack                       MessageType = "Ack"
 )
 
 type MessageCounter struct {
@@ -89,6 +108,31 @@ type Message struct {
 	Route          []int         `json:"route"`
 	Message        any           `json:"Message"`
 	Id             int64         `json:"id"`
+	// latest snapshot the original sender recorded before sending
+	Snapshot int64 `json:"snapshot,omitempty"`
+	// node that sent the message over the last hop and waits for its ack
+	Hop *node.NodeInfo `json:"hop,omitempty"`
+	// query a request belongs to, its responses carry it back
+	RequestId int64 `json:"requestId,omitempty"`
+	// logical time of the node that sent the message over the last hop
+	Clock  int64            `json:"clock,omitempty"`
+	Vector map[string]int64 `json:"vector,omitempty"`
+	// broadcasts the original sender applied before sending a causal
+	// broadcast, its own entry numbers the broadcast
+	Causal map[string]int64 `json:"causal,omitempty"`
+	// hops a broadcast may still take, counted down by every node that
+	// floods it on
+	TTL int `json:"ttl,omitempty"`
+	// set when the broadcast goes down a spanning tree instead of flooding
+	Tree *BroadcastTree `json:"tree,omitempty"`
+}
+
+// BroadcastTree is the binary tree over the ring a broadcast is sent down,
+// the node Root ids after the root is at index 0 and the children of the
+// node at index r are at 2r+1 and 2r+2.
+type BroadcastTree struct {
+	Root int `json:"root"`
+	Size int `json:"size"`
 }
 
 func (msg *Message) String() string {
@@ -127,6 +171,13 @@ func (msg *Message) MakeMeASender(node node.INode) IMessage {
 
 	msgReturn.OriginalSender = msg.OriginalSender
 	msgReturn.Reciver = msg.Reciver
+	msgReturn.Snapshot = msg.Snapshot
+	msgReturn.RequestId = msg.RequestId
+	msgReturn.Causal = msg.Causal
+	if msg.TTL > 0 {
+		msgReturn.TTL = msg.TTL - 1
+	}
+	msgReturn.Tree = msg.Tree
 
 	msgReturn.Route = append(msg.Route, node.GetId())
 
@@ -134,6 +185,13 @@ func (msg *Message) MakeMeASender(node node.INode) IMessage {
 
 }
 
+// AnswerTo makes the message the reply to the request, the asking node
+// matches them by the request id.
+func (msg *Message) AnswerTo(request Message) *Message {
+	msg.RequestId = request.RequestId
+	return msg
+}
+
 func MakeInfoMessage(sender, reciver node.INode, message any) *Message {
 	msgReturn := Message{}
 
@@ -195,14 +253,12 @@ func MakeContactMessage(sender node.NodeInfo, reciver, contact node.NodeInfo) *M
 	return &msgReturn
 }
 
-func MakeWelcomeMessage(sender, reciver node.NodeInfo, nodeId int, systemInfo map[int]node.NodeInfo) *Message {
+func MakeWelcomeMessage(sender, reciver node.NodeInfo, nodeId int, systemInfo map[int]node.NodeInfo, causal map[string]int64) *Message {
 	msgReturn := Message{}
 
 	msgReturn.Id = int64(MainCounter.Inc())
 
-	msgMap := map[string]interface{}{"id": nodeId, "systemInfo": systemInfo}
-
-	msgReturn.Message = msgMap
+	msgReturn.Message = WelcomeInfo{Id: nodeId, SystemInfo: systemInfo, Causal: causal}
 	msgReturn.MessageType = Welcome
 
 	msgReturn.OriginalSender = sender
@@ -228,6 +284,21 @@ func MakeSystemKnockMessage(sender, reciver node.NodeInfo) *Message {
 	return &msgReturn
 }
 
+func MakeJoinResponseMessage(sender, reciver node.NodeInfo, accepted bool) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = accepted
+	msgReturn.MessageType = JoinResponse
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
 func MakeJoinMessage(sender, reciver node.NodeInfo) *Message {
 	msgReturn := Message{}
 
@@ -302,9 +373,7 @@ func MakeConnectionResponseMessage(sender, reciver node.NodeInfo, accepted bool,
 
 	msgReturn.Id = int64(MainCounter.Inc())
 
-	var tmpMap = map[string]any{"accepted": accepted, "smer": smer}
-
-	msgReturn.Message = tmpMap
+	msgReturn.Message = ConnectionResponseInfo{Accepted: accepted, Smer: smer}
 	msgReturn.MessageType = ConnectionResponse
 
 	msgReturn.OriginalSender = sender
@@ -315,11 +384,16 @@ func MakeConnectionResponseMessage(sender, reciver node.NodeInfo, accepted bool,
 	return &msgReturn
 }
 
-func MakeQuitMessage(sender node.NodeInfo) *Message {
+type QuitInfo struct {
+	Node node.NodeInfo `json:"node"`
+	Heir node.NodeInfo `json:"heir"`
+}
+
+func MakeQuitMessage(sender, heir node.NodeInfo) *Message {
 	msgReturn := Message{}
 
 	msgReturn.Id = int64(MainCounter.Inc())
-	msgReturn.Message = sender.Id
+	msgReturn.Message = QuitInfo{Node: sender, Heir: heir}
 	msgReturn.MessageType = Quit
 
 	msgReturn.OriginalSender = sender
@@ -392,11 +466,11 @@ func MakeClusterConnectionResponseMessage(sender, reciver node.NodeInfo, accepte
 	return &msgReturn
 }
 
-func MakeImageInfoRequestMessage(sender, reciver node.NodeInfo) *Message {
+func MakeImageInfoRequestMessage(sender, reciver node.NodeInfo, jobName string) *Message {
 	msgReturn := Message{}
 
 	msgReturn.Id = int64(MainCounter.Inc())
-	msgReturn.Message = "ImageInfoRequest"
+	msgReturn.Message = jobName
 	msgReturn.MessageType = ImageInfoRequest
 
 	msgReturn.OriginalSender = sender
@@ -412,9 +486,7 @@ func MakeImageInfoMessage(sender, reciver node.NodeInfo, jobName string, points
 
 	msgReturn.Id = int64(MainCounter.Inc())
 
-	outMap := map[string]interface{}{"jobName": jobName, "points": points}
-
-	msgReturn.Message = outMap
+	msgReturn.Message = PointsInfo{JobName: jobName, Points: points}
 	msgReturn.MessageType = ImageInfo
 
 	msgReturn.OriginalSender = sender
@@ -492,9 +564,7 @@ func MakeClusterWelcomeMessage(sender, reciver node.NodeInfo, fractalID, jobName
 
 	msgReturn.Id = int64(MainCounter.Inc())
 
-	sentMap := map[string]string{"fractalID": fractalID, "jobName": jobName}
-
-	msgReturn.Message = sentMap
+	msgReturn.Message = ClusterWelcomeInfo{FractalId: fractalID, JobName: jobName}
 	msgReturn.MessageType = ClusterWelcome
 
 	msgReturn.OriginalSender = sender
@@ -526,9 +596,7 @@ func MakeStoppedJobInfoMessage(sender, reciver node.NodeInfo, jobName string, po
 
 	msgReturn.Id = int64(MainCounter.Inc())
 
-	outMap := map[string]interface{}{"jobName": jobName, "points": points}
-
-	msgReturn.Message = outMap
+	msgReturn.Message = PointsInfo{JobName: jobName, Points: points}
 
 	msgReturn.MessageType = StoppedJobInfo
 
@@ -592,3 +660,352 @@ func MakeUpdatedNodeMessage(sender, nodeInput node.NodeInfo) *Message {
 
 	return &msgReturn
 }
+
+func MakePingMessage(sender, reciver node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = "Ping"
+	msgReturn.MessageType = Ping
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakePongMessage(sender, reciver node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = "Pong"
+	msgReturn.MessageType = Pong
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakeCheckSuspectMessage(sender, reciver, suspect node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = suspect
+	msgReturn.MessageType = CheckSuspect
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+type SuspectStatusInfo struct {
+	Suspect node.NodeInfo `json:"suspect"`
+	Alive   bool          `json:"alive"`
+}
+
+func MakeSuspectStatusMessage(sender, reciver, suspect node.NodeInfo, alive bool) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = SuspectStatusInfo{Suspect: suspect, Alive: alive}
+	msgReturn.MessageType = SuspectStatus
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakeNodeDeadMessage(sender, deadNode node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = deadNode
+	msgReturn.MessageType = NodeDead
+
+	msgReturn.OriginalSender = sender
+	tmpReciver := new(node.NodeInfo)
+	tmpReciver.Id = -1
+	msgReturn.Reciver = *tmpReciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+type HandoffInfo struct {
+	JobName   string             `json:"jobName"`
+	FractalId string             `json:"fractalId"`
+	Points    []structures.Point `json:"points"`
+}
+
+func MakeHandoffPointsMessage(sender, reciver node.NodeInfo, jobName, fractalID string, points []structures.Point) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = HandoffInfo{JobName: jobName, FractalId: fractalID, Points: points}
+	msgReturn.MessageType = HandoffPoints
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+type ReplicaInfo struct {
+	JobName   string             `json:"jobName"`
+	FractalId string             `json:"fractalId"`
+	Points    []structures.Point `json:"points"`
+	Reset     bool               `json:"reset"`
+}
+
+func MakeReplicaPointsMessage(sender, reciver node.NodeInfo, jobName, fractalID string, points []structures.Point, reset bool) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = ReplicaInfo{JobName: jobName, FractalId: fractalID, Points: points, Reset: reset}
+	msgReturn.MessageType = ReplicaPoints
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakeKickMessage(sender, reciver node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = "Kick"
+	msgReturn.MessageType = Kick
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakeSystemStatusRequestMessage(sender, reciver node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = "SystemStatusRequest"
+	msgReturn.MessageType = SystemStatusRequest
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakeSystemStatusMessage(sender, reciver node.NodeInfo, jobStatuses map[string]job.JobStatus) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = jobStatuses
+	msgReturn.MessageType = SystemStatus
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+type TokenRequestInfo struct {
+	Node     node.NodeInfo `json:"node"`
+	Sequence int           `json:"sequence"`
+	// highest token epoch the node saw
+	Epoch int64 `json:"epoch"`
+}
+
+func MakeTokenRequestMessage(sender node.NodeInfo, sequence int, epoch int64) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = TokenRequestInfo{Node: sender, Sequence: sequence, Epoch: epoch}
+	msgReturn.MessageType = TokenRequest
+
+	msgReturn.OriginalSender = sender
+	tmpReciver := new(node.NodeInfo)
+	tmpReciver.Id = -1
+	msgReturn.Reciver = *tmpReciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+// TokenInfo is the Suzuki-Kasami token, nodes are keyed by full address.
+// Every regenerated token has a higher epoch, a token of a lower one is
+// stale.
+type TokenInfo struct {
+	LastServed map[string]int  `json:"lastServed"`
+	Queue      []node.NodeInfo `json:"queue"`
+	Epoch      int64           `json:"epoch"`
+}
+
+func MakeTokenMessage(sender, reciver node.NodeInfo, token TokenInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = token
+	msgReturn.MessageType = Token
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakeTokenHolderQueryMessage(sender node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = "TokenHolderQuery"
+	msgReturn.MessageType = TokenHolderQuery
+
+	msgReturn.OriginalSender = sender
+	tmpReciver := new(node.NodeInfo)
+	tmpReciver.Id = -1
+	msgReturn.Reciver = *tmpReciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+type TokenHolderInfo struct {
+	Holder node.NodeInfo   `json:"holder"`
+	InUse  bool            `json:"inUse"`
+	Queue  []node.NodeInfo `json:"queue"`
+}
+
+func MakeTokenHolderMessage(sender, reciver node.NodeInfo, inUse bool, queue []node.NodeInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = TokenHolderInfo{Holder: sender, InUse: inUse, Queue: queue}
+	msgReturn.MessageType = TokenHolder
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+func MakeSnapshotMarkerMessage(sender node.NodeInfo, snapshotId int64) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = snapshotId
+	msgReturn.MessageType = SnapshotMarker
+	msgReturn.Snapshot = snapshotId
+
+	msgReturn.OriginalSender = sender
+	tmpReciver := new(node.NodeInfo)
+	tmpReciver.Id = -1
+	msgReturn.Reciver = *tmpReciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+// SnapshotReportInfo is the state one node recorded. Points are counted per
+// job name and fractal id, Sent and Recived count point carrying messages
+// per node address up to the recording.
+type SnapshotReportInfo struct {
+	SnapshotId int64                     `json:"snapshotId"`
+	Node       node.NodeInfo             `json:"node"`
+	Points     map[string]map[string]int `json:"points"`
+	Sent       map[string]int            `json:"sent"`
+	Recived    map[string]int            `json:"recived"`
+}
+
+func MakeSnapshotReportMessage(sender, reciver node.NodeInfo, report SnapshotReportInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = report
+	msgReturn.MessageType = SnapshotReport
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+// SnapshotChannelInfo is a point carrying message that was sent before and
+// recived after the snapshot, it belongs to the channel state.
+type SnapshotChannelInfo struct {
+	SnapshotId int64  `json:"snapshotId"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	JobName    string `json:"jobName"`
+	FractalId  string `json:"fractalId"`
+	Points     int    `json:"points"`
+}
+
+func MakeSnapshotChannelMessage(sender, reciver node.NodeInfo, channelInfo SnapshotChannelInfo) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = channelInfo
+	msgReturn.MessageType = SnapshotChannel
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
+
+// AckInfo names the acknowledged message by the address and incarnation
+// of its original sender and its id.
+type AckInfo struct {
+	Origin      string `json:"origin"`
+	Incarnation int64  `json:"incarnation,omitempty"`
+	Id          int64  `json:"id"`
+}
+
+func MakeAckMessage(sender, reciver, origin node.NodeInfo, id int64) *Message {
+	msgReturn := Message{}
+
+	msgReturn.Id = int64(MainCounter.Inc())
+	msgReturn.Message = AckInfo{Origin: origin.GetFullAddress(), Incarnation: origin.Incarnation, Id: id}
+	msgReturn.MessageType = Ack
+
+	msgReturn.OriginalSender = sender
+	msgReturn.Reciver = reciver
+
+	msgReturn.Route = []int{sender.Id}
+
+	return &msgReturn
+}
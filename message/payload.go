@@ -0,0 +1,134 @@
+package message
+
+import (
+	"distributed/job"
+	"distributed/node"
+	"distributed/structures"
+	"encoding/json"
+	"fmt"
+)
+
+type WelcomeInfo struct {
+	Id         int                   `json:"id"`
+	SystemInfo map[int]node.NodeInfo `json:"systemInfo"`
+	// causal broadcasts SystemInfo already holds
+	Causal map[string]int64 `json:"causal,omitempty"`
+}
+
+type ConnectionResponseInfo struct {
+	Accepted bool           `json:"accepted"`
+	Smer     ConnectionSmer `json:"smer"`
+}
+
+type ClusterWelcomeInfo struct {
+	FractalId   string                `json:"fractalID"`
+	JobName     string                `json:"jobName"`
+	ClusterInfo map[int]node.NodeInfo `json:"ClusterInfo,omitempty"`
+}
+
+// PointsInfo carries the points of one job, used by ImageInfo and
+// StoppedJobInfo.
+type PointsInfo struct {
+	JobName string             `json:"jobName"`
+	Points  []structures.Point `json:"points"`
+}
+
+type payloadDecoder func(raw json.RawMessage) (any, error)
+
+func decodeAs[T any](raw json.RawMessage) (any, error) {
+	var payload T
+	if len(raw) == 0 {
+		return payload, nil
+	}
+	err := json.Unmarshal(raw, &payload)
+	return payload, err
+}
+
+// payloadTypes maps every message type to the type of its payload, a
+// message of an unknown type or with a payload of the wrong shape is
+// rejected while decoding.
+var payloadTypes = map[MessageType]payloadDecoder{
+	Info:                      decodeAs[any],
+	InfoBroadcast:             decodeAs[any],
+	Hail:                      decodeAs[string],
+	Contact:                   decodeAs[node.NodeInfo],
+	Welcome:                   decodeAs[WelcomeInfo],
+	Join:                      decodeAs[int],
+	JoinResponse:              decodeAs[bool],
+	Leave:                     decodeAs[int],
+	Entered:                   decodeAs[node.NodeInfo],
+	ConnectionRequest:         decodeAs[ConnectionSmer],
+	ConnectionResponse:        decodeAs[ConnectionResponseInfo],
+	Quit:                      decodeAs[QuitInfo],
+	ClusterKnock:              decodeAs[string],
+	EnteredCluster:            decodeAs[node.NodeInfo],
+	ClusterConnectionRequest:  decodeAs[string],
+	ClusterConnectionResponse: decodeAs[bool],
+	ImageInfoRequest:          decodeAs[string],
+	ImageInfo:                 decodeAs[PointsInfo],
+	SystemKnock:               decodeAs[string],
+	Purge:                     decodeAs[string],
+	StartJob:                  decodeAs[string],
+	StartJobGenesis:           decodeAs[string],
+	ApproachCluster:           decodeAs[node.NodeInfo],
+	ClusterWelcome:            decodeAs[ClusterWelcomeInfo],
+	StopShareJob:              decodeAs[job.Job],
+	StoppedJobInfo:            decodeAs[PointsInfo],
+	JobStatusRequest:          decodeAs[string],
+	JobStatus:                 decodeAs[job.JobStatus],
+	UpdatedNode:               decodeAs[node.NodeInfo],
+	Ping:                      decodeAs[string],
+	Pong:                      decodeAs[string],
+	CheckSuspect:              decodeAs[node.NodeInfo],
+	SuspectStatus:             decodeAs[SuspectStatusInfo],
+	NodeDead:                  decodeAs[node.NodeInfo],
+	HandoffPoints:             decodeAs[HandoffInfo],
+	ReplicaPoints:             decodeAs[ReplicaInfo],
+	Kick:                      decodeAs[string],
+	SystemStatusRequest:       decodeAs[string],
+	SystemStatus:              decodeAs[map[string]job.JobStatus],
+	TokenRequest:              decodeAs[TokenRequestInfo],
+	Token:                     decodeAs[TokenInfo],
+	TokenHolderQuery:          decodeAs[string],
+	TokenHolder:               decodeAs[TokenHolderInfo],
+	SnapshotMarker:            decodeAs[int64],
+	SnapshotReport:            decodeAs[SnapshotReportInfo],
+	SnapshotChannel:           decodeAs[SnapshotChannelInfo],
+	Ack:                       decodeAs[AckInfo],
+}
+
+func (msg *Message) UnmarshalJSON(data []byte) error {
+	type messageAlias Message
+	wire := struct {
+		*messageAlias
+		Message json.RawMessage `json:"Message"`
+	}{messageAlias: (*messageAlias)(msg)}
+
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	decode, ok := payloadTypes[msg.MessageType]
+	if !ok {
+		return fmt.Errorf("unknown message type %q", msg.MessageType)
+	}
+
+	payload, err := decode(wire.Message)
+	if err != nil {
+		return fmt.Errorf("bad %s payload: %w", msg.MessageType, err)
+	}
+	msg.Message = payload
+
+	return nil
+}
+
+// Payload returns the payload of the message as T, or an error if the
+// message carries something else.
+func Payload[T any](msg Message) (T, error) {
+	payload, ok := msg.Message.(T)
+	if !ok {
+		var expected T
+		return expected, fmt.Errorf("%s payload is %T, expected %T", msg.MessageType, msg.Message, expected)
+	}
+	return payload, nil
+}
@@ -60,6 +60,8 @@ type Worker struct {
 	Connections map[string]NodeInfo `json:"-"`
 	History     []structures.Point  `json:"-"`
 	SystemInfo  map[int]NodeInfo    `json:"-"`
+	// tells a restarted process on the same address from the one before it
+	Incarnation int64 `json:"-"`
 }
 
 func (w *Worker) GetAdders() string {
@@ -89,6 +91,7 @@ func (w *Worker) GetNodeInfo() *NodeInfo {
 	toReturn.Port = w.GetPort()
 	toReturn.JobName = w.JobName
 	toReturn.FractalId = w.FractalId
+	toReturn.Incarnation = w.Incarnation
 
 	return toReturn
 }
@@ -103,6 +106,8 @@ type NodeInfo struct {
 	Port      int    `json:"port"`
 	JobName   string `json:"JobName"`
 	FractalId string `json:"FractalId"`
+	// message ids start over when a process restarts
+	Incarnation int64 `json:"incarnation,omitempty"`
 }
 
 func (w *NodeInfo) GetFullAddress() string {
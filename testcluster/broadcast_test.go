@@ -0,0 +1,91 @@
+package testcluster_test
+
+import (
+	"context"
+	"distributed/testcluster"
+	"distributed/transport"
+	"distributed/worker"
+	"testing"
+	"time"
+)
+
+// sentForJoin adds a worker and returns what the workers sent once every
+// one of them applied its Entered.
+func sentForJoin(t *testing.T, c *testcluster.Cluster) worker.BroadcastCounts {
+	t.Helper()
+
+	before := c.BroadcastCounts()
+	if _, err := c.AddWorker(context.Background()); err != nil {
+		t.Fatalf("add worker: %v", err)
+	}
+	waitUntilAllJoined(t, c)
+	after := c.BroadcastCounts()
+
+	return worker.BroadcastCounts{
+		Started:   after.Started - before.Started,
+		Forwarded: after.Forwarded - before.Forwarded,
+		TreeSent:  after.TreeSent - before.TreeSent,
+		FloodSent: after.FloodSent - before.FloodSent,
+		Fallbacks: after.Fallbacks - before.Fallbacks,
+	}
+}
+
+func TestTreeBroadcastSendsLess(t *testing.T) {
+	counts := make(map[bool]worker.BroadcastCounts)
+	for _, tree := range []bool{true, false} {
+		tree := tree
+		name := "flood"
+		if tree {
+			name = "tree"
+		}
+		t.Run(name, func(t *testing.T) {
+			c := startCluster(t, testcluster.Options{Workers: 8, TreeBroadcast: tree})
+			waitUntilAllJoined(t, c)
+			counts[tree] = sentForJoin(t, c)
+		})
+	}
+
+	tree, flood := counts[true], counts[false]
+	if tree.TreeSent == 0 || tree.Fallbacks != 0 {
+		t.Fatalf("the Entered did not go down the tree: %+v", tree)
+	}
+	if flood.TreeSent != 0 {
+		t.Fatalf("flooding sent down a tree: %+v", flood)
+	}
+	if tree.Sent() >= flood.Sent() {
+		t.Fatalf("the tree sent %d frames and flooding %d", tree.Sent(), flood.Sent())
+	}
+}
+
+// TestTreeBroadcastFallsBackToFlooding cuts a leaf off while a worker
+// enters, so it does not know of it when the next one enters. The tree of
+// that Entered does not match its system and it floods it on.
+func TestTreeBroadcastFallsBackToFlooding(t *testing.T) {
+	faults := transport.NewFaultNetwork(1)
+	c := startCluster(t, testcluster.Options{Workers: 5, TreeBroadcast: true, Faults: faults})
+	waitUntilAllJoined(t, c)
+
+	// a leaf of the tree of id 5 and no ring neighbour of it
+	const cut = 2
+	faults.Partition([]int{cut}, []int{0, 1, 3, 4})
+	if _, err := c.AddWorker(context.Background()); err != nil {
+		t.Fatalf("add worker: %v", err)
+	}
+
+	// healed before the Entered lost on the cut link is sent again
+	deadline := time.Now().Add(worker.ACK_TIMEOUT / 2)
+	for i := 0; i < 5; i++ {
+		for i != cut && len(c.Worker(i).State().SystemInfo) != 6 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	faults.Heal()
+	if len(c.Worker(cut).State().SystemInfo) != 5 {
+		t.Fatal("the cut worker learned of the new one")
+	}
+
+	counts := sentForJoin(t, c)
+	if counts.Fallbacks == 0 || counts.FloodSent == 0 {
+		t.Fatalf("nobody flooded the Entered on: %+v", counts)
+	}
+}
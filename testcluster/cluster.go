@@ -0,0 +1,410 @@
+// Package testcluster runs a bootstrap and workers in one process over the
+// in-memory transport, so the protocol can be driven and checked from code
+// instead of from the logs of separate nodes.
+package testcluster
+
+import (
+	"context"
+	"distributed/bootstrap"
+	"distributed/job"
+	"distributed/node"
+	"distributed/trace"
+	"distributed/transport"
+	"distributed/worker"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const IP_ADDRESS = "127.0.0.1"
+const BOOTSTRAP_PORT = 7777
+const FIRST_WORKER_PORT = 6300
+
+// WAIT_TICK is how often the Wait calls look at the nodes again.
+const WAIT_TICK = 100 * time.Millisecond
+
+type Options struct {
+	Workers int
+	// jobs every worker knows of, like the jobs of the system file
+	Jobs         []job.Job
+	QueryTimeout time.Duration
+	// frames of every node go through it when set
+	Faults *transport.FaultNetwork
+	// workers send broadcasts down a spanning tree instead of flooding
+	TreeBroadcast bool
+	// every node records what it sends and recives to <address>.trace in
+	// it when set
+	TraceDir string
+	// nodes write their files under it, the working directory when empty
+	Dir string
+}
+
+// Cluster is a bootstrap and its workers. Nodes write their logs, images
+// and the bootstrap state under files in Options.Dir, like the nodes
+// started from the command line do in the working directory.
+type Cluster struct {
+	Network   *transport.MemoryNetwork
+	Faults    *transport.FaultNetwork
+	Bootstrap *bootstrap.Bootstrap
+
+	mutex   sync.Mutex
+	Workers []*worker.Worker
+	// cancel of every worker's context, canceling it crashes the worker
+	cancels  map[*worker.Worker]context.CancelFunc
+	nextPort int
+	options  Options
+}
+
+func New(options Options) *Cluster {
+	if options.QueryTimeout == 0 {
+		options.QueryTimeout = worker.DEFAULT_QUERY_TIMEOUT
+	}
+
+	return &Cluster{
+		Network:  transport.NewMemoryNetwork(),
+		Faults:   options.Faults,
+		Workers:  make([]*worker.Worker, 0, options.Workers),
+		cancels:  make(map[*worker.Worker]context.CancelFunc),
+		nextPort: FIRST_WORKER_PORT,
+		options:  options,
+	}
+}
+
+func (c *Cluster) transportFor(address string) transport.Transport {
+	memory := transport.NewMemoryTransport(c.Network)
+	if c.Faults == nil {
+		return memory
+	}
+	return c.Faults.Wrap(address, memory)
+}
+
+// recorderFor is the recorder of the node at address, nil when the cluster
+// is not traced.
+func (c *Cluster) recorderFor(address string) (*trace.Recorder, error) {
+	if c.options.TraceDir == "" {
+		return nil, nil
+	}
+	return trace.NewRecorder(c.TracePath(address), address, 0)
+}
+
+// TracePath is where the node at address records its trace.
+func (c *Cluster) TracePath(address string) string {
+	return filepath.Join(c.options.TraceDir, address+".trace")
+}
+
+// Start starts the bootstrap and lets the workers in one by one, it returns
+// once the last one was welcomed.
+func (c *Cluster) Start(ctx context.Context) error {
+	for _, dir := range []string{"output", "error", "images"} {
+		if err := os.MkdirAll(filepath.Join(c.options.Dir, "files", dir), 0755); err != nil {
+			return err
+		}
+	}
+
+	b, err := bootstrap.NewBootstrap(IP_ADDRESS, BOOTSTRAP_PORT, string(filepath.Separator))
+	if err != nil {
+		return err
+	}
+	b.SetDir(c.options.Dir)
+	// every cluster starts empty
+	os.Remove(b.StateFilePath)
+	b.Transport = c.transportFor(b.BootstrapNode.GetFullAddress())
+	if b.Recorder, err = c.recorderFor(b.BootstrapNode.GetFullAddress()); err != nil {
+		return err
+	}
+	if err := b.Start(ctx); err != nil {
+		return err
+	}
+	c.Bootstrap = b
+
+	for i := 0; i < c.options.Workers; i++ {
+		if _, err := c.AddWorker(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddWorker starts one more worker and returns once it was welcomed.
+func (c *Cluster) AddWorker(ctx context.Context) (*worker.Worker, error) {
+	c.mutex.Lock()
+	port := c.nextPort
+	c.nextPort++
+	c.mutex.Unlock()
+
+	w, err := worker.NewWorker(IP_ADDRESS, port, IP_ADDRESS, BOOTSTRAP_PORT, c.options.Jobs, string(filepath.Separator))
+	if err != nil {
+		return nil, err
+	}
+	w.SetDir(c.options.Dir)
+	w.QueryTimeout = c.options.QueryTimeout
+	w.TreeBroadcast = c.options.TreeBroadcast
+	w.Transport = c.transportFor(w.WorkerNode.GetFullAddress())
+	if w.Recorder, err = c.recorderFor(w.WorkerNode.GetFullAddress()); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if err := w.Start(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.Workers = append(c.Workers, w)
+	c.cancels[w] = cancel
+	c.mutex.Unlock()
+
+	return w, nil
+}
+
+// StopWorker makes the worker leave the system like the quit command.
+func (c *Cluster) StopWorker(i int) {
+	w, cancel := c.removeWorker(i)
+
+	w.Stop()
+	cancel()
+}
+
+// CrashWorker stops the worker without leaving the system, like a killed
+// process. The others find out from its silence.
+func (c *Cluster) CrashWorker(i int) {
+	w, cancel := c.removeWorker(i)
+
+	cancel()
+	w.Stop()
+}
+
+func (c *Cluster) removeWorker(i int) (*worker.Worker, context.CancelFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	w := c.Workers[i]
+	cancel := c.cancels[w]
+	c.Workers = append(c.Workers[:i], c.Workers[i+1:]...)
+	delete(c.cancels, w)
+	return w, cancel
+}
+
+// Stop makes every worker leave, the last started first, and stops the
+// bootstrap.
+func (c *Cluster) Stop() {
+	c.mutex.Lock()
+	workers := c.Workers
+	cancels := c.cancels
+	c.Workers = nil
+	c.cancels = make(map[*worker.Worker]context.CancelFunc)
+	c.mutex.Unlock()
+
+	for i := len(workers) - 1; i >= 0; i-- {
+		workers[i].Stop()
+		cancels[workers[i]]()
+	}
+	if c.Bootstrap != nil {
+		c.Bootstrap.Stop()
+	}
+}
+
+func (c *Cluster) running() []*worker.Worker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	workers := make([]*worker.Worker, len(c.Workers))
+	copy(workers, c.Workers)
+	return workers
+}
+
+func (c *Cluster) Worker(i int) *worker.Worker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.Workers[i]
+}
+
+// The calls below run a command of the command line on the i-th worker.
+
+func (c *Cluster) StartJob(i int, name string) {
+	c.Worker(i).StartJob(name)
+}
+
+func (c *Cluster) StopJob(i int, name string) {
+	c.Worker(i).StopJob(name)
+}
+
+func (c *Cluster) Status(i int, args string) (map[string]job.JobStatus, []node.NodeInfo) {
+	return c.Worker(i).JobStatus(args)
+}
+
+func (c *Cluster) Result(i int, args string) (job.Job, []node.NodeInfo, bool) {
+	return c.Worker(i).JobResult(args)
+}
+
+// BroadcastCounts adds up the broadcast counts of the running workers.
+func (c *Cluster) BroadcastCounts() worker.BroadcastCounts {
+	var total worker.BroadcastCounts
+	for _, w := range c.running() {
+		counts := w.BroadcastCounts()
+		total.Started += counts.Started
+		total.Forwarded += counts.Forwarded
+		total.TreeSent += counts.TreeSent
+		total.FloodSent += counts.FloodSent
+		total.Fallbacks += counts.Fallbacks
+	}
+	return total
+}
+
+// waitFor checks the cluster every WAIT_TICK until check returns nil, on
+// timeout the last reason check gave is returned.
+func (c *Cluster) waitFor(ctx context.Context, check func() error) error {
+	ticker := time.NewTicker(WAIT_TICK)
+	defer ticker.Stop()
+
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitUntilAllJoined waits until every running worker and the bootstrap
+// agree on the same ring of contiguous ids.
+func (c *Cluster) WaitUntilAllJoined(ctx context.Context) error {
+	return c.waitFor(ctx, c.checkJoined)
+}
+
+func (c *Cluster) checkJoined() error {
+	workers := c.running()
+	size := len(workers)
+
+	known := c.Bootstrap.Workers()
+	if len(known) != size {
+		return fmt.Errorf("bootstrap knows %d of %d workers", len(known), size)
+	}
+
+	addresses := make(map[int]string)
+	for _, w := range workers {
+		state := w.State()
+		if !state.Joined {
+			return fmt.Errorf("%s did not join", state.Info.GetFullAddress())
+		}
+		if len(state.SystemInfo) != size {
+			return fmt.Errorf("%s knows %d of %d workers", state.Info.String(), len(state.SystemInfo), size)
+		}
+		id := state.Info.Id
+		if id < 0 || id >= size {
+			return fmt.Errorf("%s has an id out of the ring", state.Info.String())
+		}
+		if other, ok := addresses[id]; ok {
+			return fmt.Errorf("%s and %s have the same id", other, state.Info.String())
+		}
+		addresses[id] = state.Info.GetFullAddress()
+
+		if size > 1 && (state.Next != (id+1)%size || state.Prev != (id+size-1)%size) {
+			return fmt.Errorf("%s has NEXT: %d & PREV: %d", state.Info.String(), state.Next, state.Prev)
+		}
+	}
+
+	for _, w := range workers {
+		state := w.State()
+		for id, val := range state.SystemInfo {
+			if addresses[id] != val.GetFullAddress() {
+				return fmt.Errorf("%s has %s as %d", state.Info.String(), val.GetFullAddress(), id)
+			}
+		}
+	}
+	return nil
+}
+
+// WaitUntilJobSplit waits until the workers working on the job cover the
+// whole of it once. Workers still waiting for their siblings to split a
+// part do not count yet.
+func (c *Cluster) WaitUntilJobSplit(ctx context.Context, name string) error {
+	return c.waitFor(ctx, func() error {
+		return c.checkJobSplit(name)
+	})
+}
+
+func (c *Cluster) checkJobSplit(name string) error {
+	var pointCount int
+	for _, val := range c.options.Jobs {
+		if val.Name == name {
+			pointCount = val.PointCount
+		}
+	}
+	if pointCount < 2 {
+		return fmt.Errorf("there is no job %s", name)
+	}
+
+	fractalIds := make(map[string]string)
+	for _, w := range c.running() {
+		info := w.State().Info
+		if info.JobName != name {
+			continue
+		}
+		if len(info.FractalId) == 0 {
+			return fmt.Errorf("%s has no fractal id", info.String())
+		}
+		if other, ok := fractalIds[info.FractalId]; ok {
+			return fmt.Errorf("%s and %s have the same fractal id", other, info.String())
+		}
+		fractalIds[info.FractalId] = info.String()
+	}
+	if len(fractalIds) == 0 {
+		return fmt.Errorf("no worker is on %s", name)
+	}
+
+	// the first parts wait for each other like the children of a split part
+	// wait for their parent
+	firstParts := make(map[byte]bool)
+	for fractalId := range fractalIds {
+		firstParts[fractalId[0]] = true
+	}
+
+	working := make([]string, 0, len(fractalIds))
+	depth := 0
+	for fractalId := range fractalIds {
+		if len(fractalId) == 1 && fractalId != "0" && len(firstParts) < pointCount {
+			continue
+		}
+		if _, waiting := fractalIds[fractalId[:len(fractalId)-1]]; waiting {
+			continue
+		}
+		working = append(working, fractalId)
+		if len(fractalId) > depth {
+			depth = len(fractalId)
+		}
+	}
+
+	// the first node works on the whole job until the first split
+	if len(working) == 1 && working[0] == "0" {
+		return nil
+	}
+
+	// a node of fractal id f works on 1/pointCount^len(f) of the job,
+	// counted in parts of the deepest level
+	whole := 1
+	for i := 0; i < depth; i++ {
+		whole *= pointCount
+	}
+	covered := 0
+	for _, fractalId := range working {
+		part := 1
+		for i := len(fractalId); i < depth; i++ {
+			part *= pointCount
+		}
+		covered += part
+	}
+	if covered != whole {
+		return fmt.Errorf("workers on %s cover %d of %d parts: %v", name, covered, whole, working)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package testcluster_test
+
+import (
+	"context"
+	"distributed/job"
+	"distributed/structures"
+	"distributed/testcluster"
+	"testing"
+	"time"
+)
+
+// WAIT_TIMEOUT is long enough for a silent worker to be declared dead and
+// for a job to be split between the workers.
+const WAIT_TIMEOUT = 60 * time.Second
+
+var triangle = job.Job{
+	Name:       "triangle",
+	PointCount: 3,
+	Ratio:      0.5,
+	Width:      1000,
+	Height:     1000,
+	MainPoints: []structures.Point{{X: 100, Y: 100}, {X: 10, Y: 900}, {X: 950, Y: 900}},
+}
+
+// startCluster starts a cluster in a directory of its own, it is stopped
+// when the test ends.
+func startCluster(t *testing.T, options testcluster.Options) *testcluster.Cluster {
+	t.Helper()
+	if options.Dir == "" {
+		options.Dir = t.TempDir()
+	}
+	if options.QueryTimeout == 0 {
+		options.QueryTimeout = 2 * time.Second
+	}
+	c := testcluster.New(options)
+	t.Cleanup(c.Stop)
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	return c
+}
+
+func waitUntilAllJoined(t *testing.T, c *testcluster.Cluster) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), WAIT_TIMEOUT)
+	defer cancel()
+
+	if err := c.WaitUntilAllJoined(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	c := startCluster(t, testcluster.Options{Workers: 5})
+	waitUntilAllJoined(t, c)
+
+	if _, err := c.AddWorker(context.Background()); err != nil {
+		t.Fatalf("add worker: %v", err)
+	}
+	waitUntilAllJoined(t, c)
+}
+
+func TestLeave(t *testing.T) {
+	c := startCluster(t, testcluster.Options{Workers: 4})
+	waitUntilAllJoined(t, c)
+
+	c.StopWorker(1)
+	waitUntilAllJoined(t, c)
+
+	c.StopWorker(0)
+	waitUntilAllJoined(t, c)
+
+	if got := len(c.Bootstrap.Workers()); got != 2 {
+		t.Fatalf("bootstrap knows %d workers, want 2", got)
+	}
+}
+
+func TestCrashRecovery(t *testing.T) {
+	c := startCluster(t, testcluster.Options{Workers: 4})
+	waitUntilAllJoined(t, c)
+
+	c.CrashWorker(2)
+	waitUntilAllJoined(t, c)
+
+	// the system still lets nodes in after it
+	if _, err := c.AddWorker(context.Background()); err != nil {
+		t.Fatalf("add worker: %v", err)
+	}
+	waitUntilAllJoined(t, c)
+}
+
+func TestJobSplitAfterCrash(t *testing.T) {
+	c := startCluster(t, testcluster.Options{Workers: 4, Jobs: []job.Job{triangle}})
+	waitUntilAllJoined(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), WAIT_TIMEOUT)
+	defer cancel()
+
+	c.StartJob(0, triangle.Name)
+	if err := c.WaitUntilJobSplit(ctx, triangle.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	c.CrashWorker(3)
+	waitUntilAllJoined(t, c)
+	if err := c.WaitUntilJobSplit(ctx, triangle.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	result, missing, ok := c.Result(0, triangle.Name)
+	if !ok {
+		t.Fatalf("job %s is not working", triangle.Name)
+	}
+	if len(missing) > 0 {
+		t.Fatalf("no result from %v", missing)
+	}
+	if len(result.Points) == 0 {
+		t.Fatal("the job has no points")
+	}
+}
+
+func TestStatusAndStop(t *testing.T) {
+	c := startCluster(t, testcluster.Options{Workers: 4, Jobs: []job.Job{triangle}})
+	waitUntilAllJoined(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), WAIT_TIMEOUT)
+	defer cancel()
+
+	c.StartJob(0, triangle.Name)
+	if err := c.WaitUntilJobSplit(ctx, triangle.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, missing := c.Status(1, triangle.Name)
+	if len(missing) > 0 {
+		t.Fatalf("no status from %v", missing)
+	}
+	status, ok := statuses[triangle.Name]
+	if !ok {
+		t.Fatalf("no status of %s in %v", triangle.Name, statuses)
+	}
+	if status.WorkingNodes == 0 || len(status.PointsPerNodes) == 0 {
+		t.Fatalf("status of a started job: %s", status.Log())
+	}
+
+	c.StopJob(2, triangle.Name)
+	for {
+		statuses, _ := c.Status(0, triangle.Name)
+		_, _, working := c.Result(0, triangle.Name)
+		if _, ok := statuses[triangle.Name]; !ok && !working {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%s still works after stop: %v", triangle.Name, statuses)
+		case <-time.After(testcluster.WAIT_TICK):
+		}
+	}
+}
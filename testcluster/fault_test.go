@@ -0,0 +1,42 @@
+package testcluster_test
+
+import (
+	"distributed/message"
+	"distributed/testcluster"
+	"distributed/transport"
+	"testing"
+	"time"
+)
+
+// PARTITION_TIME is shorter than the silence after which a node is
+// suspected, the sides only lose the frames sent meanwhile.
+const PARTITION_TIME = 3 * time.Second
+
+var ringTypes = []message.MessageType{
+	message.SystemKnock, message.Welcome, message.ConnectionRequest, message.ConnectionResponse,
+	message.Entered, message.Quit, message.UpdatedNode, message.Ack,
+}
+
+// TestDropPartitionHeal lets a worker leave while the system is cut in two
+// and frames are dropped, the workers agree again once the network heals.
+func TestDropPartitionHeal(t *testing.T) {
+	faults := transport.NewFaultNetwork(7)
+	// messages to and from the bootstrap are not acked, only the ones
+	// between workers are sent again when lost
+	faults.AddRule(transport.FaultRule{Kind: transport.Drop, Types: ringTypes, Probability: 0.1})
+
+	c := startCluster(t, testcluster.Options{Workers: 4, Faults: faults})
+	waitUntilAllJoined(t, c)
+
+	faults.Partition([]int{0, 1}, []int{2, 3})
+	c.StopWorker(1)
+	time.Sleep(PARTITION_TIME)
+
+	counts := faults.Counts()
+	if counts[transport.Drop] == 0 || counts[transport.Partitioned] == 0 {
+		t.Fatalf("the faults were not applied: %v", counts)
+	}
+
+	faults.Heal()
+	waitUntilAllJoined(t, c)
+}
@@ -0,0 +1,82 @@
+package testcluster_test
+
+import (
+	"bytes"
+	"context"
+	"distributed/bootstrap"
+	"distributed/testcluster"
+	"distributed/trace"
+	"distributed/worker"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// loadTrace reads what the node at address recorded so far.
+func loadTrace(t *testing.T, c *testcluster.Cluster, address string) []trace.Record {
+	t.Helper()
+
+	records, err := trace.Load(c.TracePath(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestReplayReachesTheRecordedState(t *testing.T) {
+	dir := t.TempDir()
+	c := startCluster(t, testcluster.Options{Workers: 3, TraceDir: t.TempDir(), Dir: dir})
+	waitUntilAllJoined(t, c)
+
+	bootstrapAddress := c.Bootstrap.BootstrapNode.GetFullAddress()
+	recordedWorkers := c.Bootstrap.Workers()
+	bootstrapRecords := loadTrace(t, c, bootstrapAddress)
+
+	w := c.Worker(1)
+	recordedState := w.State()
+	workerRecords := loadTrace(t, c, recordedState.Info.GetFullAddress())
+
+	statePath := c.Bootstrap.StateFilePath
+	savedState, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := bootstrap.NewBootstrap(testcluster.IP_ADDRESS, testcluster.BOOTSTRAP_PORT, string(filepath.Separator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.SetDir(dir)
+	if err := b.Replay(context.Background(), bootstrapRecords, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Workers(); !reflect.DeepEqual(got, recordedWorkers) {
+		t.Fatalf("replayed bootstrap knows %v, recorded %v", got, recordedWorkers)
+	}
+
+	// the replay keeps the worker list of the recorded run
+	if state, err := os.ReadFile(statePath); err != nil || !bytes.Equal(state, savedState) {
+		t.Fatalf("replay changed %s: %v", statePath, err)
+	}
+
+	replayed, err := worker.NewWorker(recordedState.Info.IpAddress, recordedState.Info.Port, testcluster.IP_ADDRESS, testcluster.BOOTSTRAP_PORT, nil, string(filepath.Separator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed.SetDir(dir)
+	if err := replayed.Replay(context.Background(), workerRecords, false); err != nil {
+		t.Fatal(err)
+	}
+	state := replayed.State()
+	if state.Info.Id != recordedState.Info.Id || state.Next != recordedState.Next || state.Prev != recordedState.Prev {
+		t.Fatalf("replayed worker is %s with NEXT: %d & PREV: %d, recorded %s with NEXT: %d & PREV: %d",
+			state.Info.String(), state.Next, state.Prev, recordedState.Info.String(), recordedState.Next, recordedState.Prev)
+	}
+	for id, val := range recordedState.SystemInfo {
+		got := state.SystemInfo[id]
+		if got.GetFullAddress() != val.GetFullAddress() {
+			t.Fatalf("replayed worker has %s as %d, recorded %s", got.GetFullAddress(), id, val.GetFullAddress())
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package testcluster_test
+
+import (
+	"context"
+	"distributed/testcluster"
+	"testing"
+)
+
+// TestRingOf20 runs a ring of 20 workers on the memory transport, no real
+// ports are used.
+func TestRingOf20(t *testing.T) {
+	c := startCluster(t, testcluster.Options{Workers: 20})
+	waitUntilAllJoined(t, c)
+
+	// leaving and entering rewires the ring at both ends
+	c.StopWorker(10)
+	waitUntilAllJoined(t, c)
+	if _, err := c.AddWorker(context.Background()); err != nil {
+		t.Fatalf("add worker: %v", err)
+	}
+	waitUntilAllJoined(t, c)
+
+	if got := len(c.Bootstrap.Workers()); got != 20 {
+		t.Fatalf("bootstrap knows %d workers, want 20", got)
+	}
+}
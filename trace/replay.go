@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"bufio"
+	"distributed/message"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// REPLAY_SETTLE is how long the handlers still running after the last
+// message get to finish.
+const REPLAY_SETTLE = 1 * time.Second
+
+// REPLAY_HANDLER_WAIT is how long the next message waits for the handler of
+// a replayed one. Nothing is sent in a replay, so a handler waiting for a
+// reply is left running.
+const REPLAY_HANDLER_WAIT = 2 * time.Second
+
+// ReplayPath is the path a replay writes the file at path to, so the logs
+// and state of the recorded run are kept.
+func ReplayPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "_replay" + ext
+}
+
+// LoadNode reads a trace and the address of the node that recorded it.
+func LoadNode(path string) ([]Record, string, int, error) {
+	records, err := Load(path)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if len(records) == 0 {
+		return nil, "", 0, fmt.Errorf("nothing to replay in %s", path)
+	}
+
+	ipAddres, portString, err := net.SplitHostPort(records[0].Node)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return records, ipAddres, port, nil
+}
+
+// Handle runs the handler of a recived message in a goroutine of its own.
+// In a replay it waits for it so the messages are handled in order, false
+// means the handler still ran after REPLAY_HANDLER_WAIT.
+func Handle(replaying bool, handler func(message.Message), msgStruct message.Message) bool {
+	if !replaying {
+		go handler(msgStruct)
+		return true
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handler(msgStruct)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(REPLAY_HANDLER_WAIT):
+		return false
+	}
+}
+
+// Play feeds the recived messages of the records to recive in the order they
+// were recived, with the random numbers seeded as in the recorded run. With
+// step set it waits for enter before every message.
+func Play(records []Record, step bool, recive func(message.Message)) error {
+	if len(records) > 0 {
+		rand.Seed(records[0].Seed)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for ind, record := range records {
+		if record.Direction != In {
+			continue
+		}
+
+		fmt.Printf("%d/%d [%s] %s\n", ind+1, len(records), record.Time.Format("15:04:05.000"), record.Message.Log())
+		if step {
+			if _, err := reader.ReadString('\n'); err != nil {
+				return err
+			}
+		}
+		recive(record.Message)
+	}
+	return nil
+}
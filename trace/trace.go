@@ -0,0 +1,112 @@
+// Package trace records the messages a node recives and sends as JSON lines,
+// so a run that went wrong can be fed to the node again offline.
+package trace
+
+import (
+	"distributed/message"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	In  Direction = "in"
+	Out Direction = "out"
+)
+
+type Record struct {
+	Time time.Time `json:"time"`
+	// seed of the random numbers of the node, replay seeds them again
+	Seed      int64     `json:"seed"`
+	Node      string    `json:"node"`
+	Direction Direction `json:"direction"`
+	// address an outbound message was sent to
+	Peer    string          `json:"peer,omitempty"`
+	Message message.Message `json:"message"`
+}
+
+// Recorder writes one record per line. A nil Recorder records nothing, so
+// nodes call it whether recording is on or not.
+type Recorder struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	node    string
+	seed    int64
+	closed  bool
+}
+
+func NewRecorder(path, node string, seed int64) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{file: file, encoder: json.NewEncoder(file), node: node, seed: seed}, nil
+}
+
+func (r *Recorder) Record(direction Direction, peer string, msg message.Message) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// the outbox can still resend while the node stops
+	if r.closed {
+		return nil
+	}
+
+	return r.encoder.Encode(Record{
+		Time:      time.Now(),
+		Seed:      r.seed,
+		Node:      r.node,
+		Direction: direction,
+		Peer:      peer,
+		Message:   msg,
+	})
+}
+
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.file.Close()
+}
+
+// Load reads every record of a trace in the order they were written.
+func Load(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]Record, 0)
+	decoder := json.NewDecoder(file)
+	for {
+		var record Record
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const DIAL_TIMEOUT = 1 * time.Second
+const WRITE_TIMEOUT = 5 * time.Second
+
+type peerConn struct {
+	mutex  sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// ConnManager keeps one long-lived connection to every peer that is sent
+// to with keep set, the ring neighbours and cluster connections of a worker.
+// Other messages use a connection of their own.
+type ConnManager struct {
+	dial  func(address string) (net.Conn, error)
+	mutex sync.Mutex
+	peers map[string]*peerConn
+}
+
+func NewConnManager(dial func(address string) (net.Conn, error)) *ConnManager {
+	return &ConnManager{dial: dial, peers: make(map[string]*peerConn)}
+}
+
+// SendOnce dials the address, writes one frame and closes the connection.
+func (cm *ConnManager) SendOnce(address string, data []byte) error {
+	conn, err := cm.dial(address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	return WriteFrame(conn, data)
+}
+
+func (cm *ConnManager) Send(address string, data []byte, keep bool) error {
+	if !keep {
+		return cm.SendOnce(address, data)
+	}
+
+	cm.mutex.Lock()
+	peer, ok := cm.peers[address]
+	if !ok {
+		peer = &peerConn{}
+		cm.peers[address] = peer
+	}
+	cm.mutex.Unlock()
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if peer.closed {
+		// dropped by Retain while we waited for it
+		return cm.SendOnce(address, data)
+	}
+
+	// a connection that broke since the last send is redialed once
+	for attempt := 0; attempt < 2; attempt++ {
+		if peer.conn == nil {
+			conn, err := cm.dial(address)
+			if err != nil {
+				return err
+			}
+			peer.conn = conn
+			go watchClose(peer, conn)
+		}
+
+		peer.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+		err := WriteFrame(peer.conn, data)
+		if err == nil {
+			return nil
+		}
+		peer.conn.Close()
+		peer.conn = nil
+		if attempt == 1 {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchClose drops the connection as soon as the peer closes it, so a dead
+// peer fails the next send instead of filling the socket buffer.
+func watchClose(peer *peerConn, conn net.Conn) {
+	io.Copy(io.Discard, conn)
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if peer.conn == conn {
+		peer.conn.Close()
+		peer.conn = nil
+	}
+}
+
+// Retain closes the kept connections to every address not in addresses.
+func (cm *ConnManager) Retain(addresses []string) {
+	keep := make(map[string]bool)
+	for _, address := range addresses {
+		keep[address] = true
+	}
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	for address, peer := range cm.peers {
+		if keep[address] {
+			continue
+		}
+		delete(cm.peers, address)
+		go closePeer(peer)
+	}
+}
+
+func (cm *ConnManager) Close() {
+	cm.Retain(nil)
+}
+
+func closePeer(peer *peerConn) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.closed = true
+
+	if peer.conn != nil {
+		peer.conn.Close()
+		peer.conn = nil
+	}
+}
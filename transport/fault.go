@@ -0,0 +1,398 @@
+package transport
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// REORDER_HOLD is the longest a frame is held back to be overtaken by the
+// next frame to the same address.
+const REORDER_HOLD = 200 * time.Millisecond
+
+type FaultKind int
+
+const (
+	Drop FaultKind = iota
+	Delay
+	Duplicate
+	Reorder
+	Partitioned
+)
+
+func (fk FaultKind) String() string {
+	switch fk {
+	case Drop:
+		return "Drop"
+	case Delay:
+		return "Delay"
+	case Duplicate:
+		return "Duplicate"
+	case Reorder:
+		return "Reorder"
+	case Partitioned:
+		return "Partitioned"
+	}
+	return "Unknown"
+}
+
+// FaultRule picks the frames a fault is applied to. Empty Types or Nodes
+// match every frame, Nodes match the two ends of the link a frame goes
+// over, so a forwarded message is picked on its hops and not by its origin.
+type FaultRule struct {
+	Kind        FaultKind
+	Types       []message.MessageType
+	Nodes       []int
+	Probability float64
+	// upper bound of a Delay, the frame waits a random time below it
+	MaxDelay time.Duration
+}
+
+// matches takes the ids of the ends of the link, -1 for an end whose id
+// is not known yet.
+func (fr *FaultRule) matches(frame *frameInfo, fromId, toId int) bool {
+	if len(fr.Types) > 0 {
+		found := false
+		for _, val := range fr.Types {
+			if val == frame.MessageType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(fr.Nodes) > 0 {
+		for _, val := range fr.Nodes {
+			if val == fromId || val == toId {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// the parts of a message the faults are picked by
+type frameInfo struct {
+	MessageType    message.MessageType `json:"MessageType"`
+	OriginalSender node.NodeInfo       `json:"sender"`
+	Reciver        node.NodeInfo       `json:"reciver"`
+	Hop            *node.NodeInfo      `json:"hop,omitempty"`
+	Payload        json.RawMessage     `json:"Message"`
+}
+
+// FaultNetwork applies the same rules and partitions to every transport
+// it wraps. Every decision is drawn from the seed, the type, sender,
+// reciver and payload of the message and how many times such a message
+// went over the same link. Message ids and clocks depend on how the
+// goroutines ran and are left out, so the same seed gives the same faults
+// to the same messages sent over each link in the same order.
+type FaultNetwork struct {
+	seed int64
+
+	mutex      sync.Mutex
+	rules      []FaultRule
+	partitions map[int]int
+	nodeIds    map[string]int
+	sent       map[uint64]int64
+	counts     map[FaultKind]int
+}
+
+func NewFaultNetwork(seed int64) *FaultNetwork {
+	return &FaultNetwork{
+		seed:       seed,
+		rules:      make([]FaultRule, 0),
+		partitions: make(map[int]int),
+		nodeIds:    make(map[string]int),
+		sent:       make(map[uint64]int64),
+		counts:     make(map[FaultKind]int),
+	}
+}
+
+func (fn *FaultNetwork) Seed() int64 {
+	return fn.seed
+}
+
+func (fn *FaultNetwork) AddRule(rule FaultRule) {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+
+	fn.rules = append(fn.rules, rule)
+}
+
+// Partition cuts every link between nodes of different groups, nodes in no
+// group and the bootstrap can still reach everyone.
+func (fn *FaultNetwork) Partition(groups ...[]int) {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+
+	fn.partitions = make(map[int]int)
+	for group, ids := range groups {
+		for _, id := range ids {
+			fn.partitions[id] = group
+		}
+	}
+}
+
+// Heal removes every rule and partition, frames already delayed or held
+// back are still delivered.
+func (fn *FaultNetwork) Heal() {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+
+	fn.rules = make([]FaultRule, 0)
+	fn.partitions = make(map[int]int)
+}
+
+// Counts tells how many frames every kind of fault was applied to.
+func (fn *FaultNetwork) Counts() map[FaultKind]int {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+
+	counts := make(map[FaultKind]int)
+	for key, val := range fn.counts {
+		counts[key] = val
+	}
+	return counts
+}
+
+// Wrap puts the faults of the network in front of the transport of the
+// node listening on address.
+func (fn *FaultNetwork) Wrap(address string, inner Transport) Transport {
+	return &faultTransport{
+		network: fn,
+		address: address,
+		inner:   inner,
+		held:    make(map[string]*heldFrame),
+	}
+}
+
+// decide returns the faults applied to one frame and how long it is
+// delayed.
+func (fn *FaultNetwork) decide(from, to string, data []byte) ([]FaultKind, time.Duration) {
+	var frame frameInfo
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, 0
+	}
+
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+
+	// ids of nodes change when the system is compacted, the latest one seen
+	// for an address is used
+	fn.learn(frame.OriginalSender)
+	fn.learn(frame.Reciver)
+	if frame.Hop != nil {
+		fn.learn(*frame.Hop)
+	}
+
+	if fn.cut(from, to) {
+		fn.counts[Partitioned]++
+		return []FaultKind{Partitioned}, 0
+	}
+
+	hash := fnv.New64a()
+	for _, val := range []string{from, to, string(frame.MessageType), frame.OriginalSender.GetFullAddress(), frame.Reciver.GetFullAddress()} {
+		hash.Write([]byte(val))
+		hash.Write([]byte{0})
+	}
+	hash.Write(frame.Payload)
+	frameHash := hash.Sum64()
+	// sequence number of the message on the link
+	fn.sent[frameHash]++
+
+	var seedBytes [16]byte
+	binary.BigEndian.PutUint64(seedBytes[:8], uint64(fn.seed))
+	binary.BigEndian.PutUint64(seedBytes[8:], uint64(fn.sent[frameHash]))
+	hash.Write(seedBytes[:])
+	random := rand.New(rand.NewSource(int64(hash.Sum64())))
+
+	fromId, toId := fn.idOf(from), fn.idOf(to)
+	faults := make([]FaultKind, 0)
+	var delay time.Duration
+	for _, rule := range fn.rules {
+		if !rule.matches(&frame, fromId, toId) || random.Float64() >= rule.Probability {
+			continue
+		}
+		if rule.Kind == Delay && rule.MaxDelay > 0 {
+			delay += time.Duration(random.Int63n(int64(rule.MaxDelay)))
+		}
+		faults = append(faults, rule.Kind)
+		fn.counts[rule.Kind]++
+		if rule.Kind == Drop {
+			break
+		}
+	}
+	return faults, delay
+}
+
+func (fn *FaultNetwork) learn(info node.NodeInfo) {
+	if len(info.IpAddress) == 0 || info.Id < 0 {
+		return
+	}
+	fn.nodeIds[info.GetFullAddress()] = info.Id
+}
+
+func (fn *FaultNetwork) idOf(address string) int {
+	if id, ok := fn.nodeIds[address]; ok {
+		return id
+	}
+	return -1
+}
+
+func (fn *FaultNetwork) cut(from, to string) bool {
+	fromId, ok := fn.nodeIds[from]
+	if !ok {
+		return false
+	}
+	toId, ok := fn.nodeIds[to]
+	if !ok {
+		return false
+	}
+	fromGroup, ok := fn.partitions[fromId]
+	if !ok {
+		return false
+	}
+	toGroup, ok := fn.partitions[toId]
+	if !ok {
+		return false
+	}
+	return fromGroup != toGroup
+}
+
+type heldFrame struct {
+	data  []byte
+	keep  bool
+	timer *time.Timer
+}
+
+// faultTransport loses frames silently like a network would, the sender
+// only sees the errors of the wrapped transport.
+type faultTransport struct {
+	network *FaultNetwork
+	address string
+	inner   Transport
+
+	mutex  sync.Mutex
+	held   map[string]*heldFrame
+	closed bool
+}
+
+func (ft *faultTransport) Send(address string, data []byte, keep bool) error {
+	faults, delay := ft.network.decide(ft.address, address, data)
+
+	copies := 1
+	reorder := false
+	for _, val := range faults {
+		switch val {
+		case Drop, Partitioned:
+			return nil
+		case Duplicate:
+			copies++
+		case Reorder:
+			reorder = true
+		}
+	}
+
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			ft.deliver(address, data, keep, copies)
+		})
+		return nil
+	}
+
+	if reorder {
+		ft.hold(address, data, keep, copies)
+		return nil
+	}
+
+	err := ft.sendCopies(address, data, keep, copies)
+	ft.release(address)
+	return err
+}
+
+func (ft *faultTransport) sendCopies(address string, data []byte, keep bool, copies int) error {
+	var err error
+	for i := 0; i < copies; i++ {
+		if sendErr := ft.inner.Send(address, data, keep); sendErr != nil && err == nil {
+			err = sendErr
+		}
+	}
+	return err
+}
+
+// deliver sends a delayed frame unless the transport was closed meanwhile.
+func (ft *faultTransport) deliver(address string, data []byte, keep bool, copies int) {
+	ft.mutex.Lock()
+	closed := ft.closed
+	ft.mutex.Unlock()
+
+	if !closed {
+		ft.sendCopies(address, data, keep, copies)
+	}
+}
+
+// hold keeps the frame back until the next frame to the same address was
+// sent, a frame already held back is sent first.
+func (ft *faultTransport) hold(address string, data []byte, keep bool, copies int) {
+	ft.release(address)
+
+	held := &heldFrame{data: data, keep: keep}
+	held.timer = time.AfterFunc(REORDER_HOLD, func() {
+		ft.release(address)
+	})
+
+	ft.mutex.Lock()
+	ft.held[address] = held
+	ft.mutex.Unlock()
+
+	for i := 1; i < copies; i++ {
+		ft.inner.Send(address, data, keep)
+	}
+}
+
+func (ft *faultTransport) release(address string) {
+	ft.mutex.Lock()
+	held, ok := ft.held[address]
+	if ok {
+		delete(ft.held, address)
+	}
+	closed := ft.closed
+	ft.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+	held.timer.Stop()
+	if !closed {
+		ft.inner.Send(address, held.data, held.keep)
+	}
+}
+
+func (ft *faultTransport) Retain(addresses []string) {
+	ft.inner.Retain(addresses)
+}
+
+func (ft *faultTransport) Listen(address string, handle func(conn net.Conn, data []byte), onError func(conn net.Conn, err error)) (*Server, error) {
+	return ft.inner.Listen(address, handle, onError)
+}
+
+func (ft *faultTransport) Close() error {
+	ft.mutex.Lock()
+	ft.closed = true
+	for address, held := range ft.held {
+		held.timer.Stop()
+		delete(ft.held, address)
+	}
+	ft.mutex.Unlock()
+
+	return ft.inner.Close()
+}
@@ -0,0 +1,151 @@
+package transport_test
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"distributed/transport"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var nodes = []node.NodeInfo{
+	{Id: 0, IpAddress: "127.0.0.1", Port: 6300},
+	{Id: 1, IpAddress: "127.0.0.1", Port: 6301},
+	{Id: 2, IpAddress: "127.0.0.1", Port: 6302},
+}
+
+// sendScript sends the same frames over the links of the nodes every time,
+// only the ids and clocks of the messages differ between runs.
+func sendScript(t *testing.T, seed int64, run int64) map[transport.FaultKind]int {
+	t.Helper()
+
+	faults := transport.NewFaultNetwork(seed)
+	faults.AddRule(transport.FaultRule{Kind: transport.Drop, Probability: 0.2})
+	faults.AddRule(transport.FaultRule{Kind: transport.Duplicate, Probability: 0.2})
+	faults.AddRule(transport.FaultRule{Kind: transport.Delay, Probability: 0.2, MaxDelay: time.Millisecond})
+	faults.AddRule(transport.FaultRule{Kind: transport.Reorder, Types: []message.MessageType{message.TokenRequest}, Probability: 0.3})
+
+	transports := make([]transport.Transport, len(nodes))
+	for ind, val := range nodes {
+		transports[ind] = faults.Wrap(val.GetFullAddress(), transport.NewDiscardTransport())
+	}
+	defer func() {
+		for _, val := range transports {
+			val.Close()
+		}
+	}()
+
+	for i := 0; i < 300; i++ {
+		from, to := nodes[i%len(nodes)], nodes[(i+1)%len(nodes)]
+
+		var msg *message.Message
+		switch i % 3 {
+		case 0:
+			msg = message.MakePingMessage(from, to)
+		case 1:
+			msg = message.MakeTokenRequestMessage(from, i/10, 0)
+		case 2:
+			msg = message.MakeUpdatedNodeMessage(from, to)
+		}
+		msg.Id = run*1000 + int64(i)
+		msg.Clock = run*7 + int64(i)
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transports[from.Id].Send(to.GetFullAddress(), data, true)
+	}
+
+	return faults.Counts()
+}
+
+func TestSameSeedSameFaults(t *testing.T) {
+	first := sendScript(t, 42, 1)
+	second := sendScript(t, 42, 2)
+
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("same seed gave %v and %v", first, second)
+	}
+	if first[transport.Drop] == 0 || first[transport.Duplicate] == 0 || first[transport.Delay] == 0 || first[transport.Reorder] == 0 {
+		t.Fatalf("some rule was never applied: %v", first)
+	}
+}
+
+func TestOtherSeedOtherFaults(t *testing.T) {
+	first := sendScript(t, 42, 1)
+	second := sendScript(t, 43, 1)
+
+	if reflect.DeepEqual(first, second) {
+		t.Fatalf("seeds 42 and 43 both gave %v", first)
+	}
+}
+
+func TestPartitionAndHeal(t *testing.T) {
+	faults := transport.NewFaultNetwork(1)
+	faults.Partition([]int{0}, []int{1, 2})
+
+	sender := faults.Wrap(nodes[0].GetFullAddress(), transport.NewDiscardTransport())
+	defer sender.Close()
+
+	send := func(to node.NodeInfo) {
+		data, err := json.Marshal(message.MakePingMessage(nodes[0], to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender.Send(to.GetFullAddress(), data, true)
+	}
+
+	send(nodes[1])
+	send(nodes[2])
+	if got := faults.Counts()[transport.Partitioned]; got != 2 {
+		t.Fatalf("%d frames cut by the partition, want 2", got)
+	}
+
+	faults.Heal()
+	send(nodes[1])
+	send(nodes[2])
+	if got := faults.Counts()[transport.Partitioned]; got != 2 {
+		t.Fatalf("%d frames cut after healing, want 2", got)
+	}
+}
+
+// a broadcast keeps its original sender and has no reciver, a node rule
+// picks it by the link it is forwarded over
+func TestNodeRuleDropsForwardedBroadcast(t *testing.T) {
+	faults := transport.NewFaultNetwork(1)
+
+	transports := make([]transport.Transport, len(nodes))
+	for ind, val := range nodes {
+		transports[ind] = faults.Wrap(val.GetFullAddress(), transport.NewDiscardTransport())
+		defer transports[ind].Close()
+	}
+
+	send := func(from, to node.NodeInfo, msg *message.Message) {
+		hop := from
+		msg.Hop = &hop
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transports[from.Id].Send(to.GetFullAddress(), data, true)
+	}
+
+	// the network learns the id of node 2 from its frames
+	send(nodes[2], nodes[0], message.MakePingMessage(nodes[2], nodes[0]))
+
+	faults.AddRule(transport.FaultRule{Kind: transport.Drop, Nodes: []int{2}, Probability: 1})
+	broadcast := message.MakeTokenRequestMessage(nodes[0], 1, 0)
+
+	send(nodes[0], nodes[1], broadcast)
+	if got := faults.Counts()[transport.Drop]; got != 0 {
+		t.Fatalf("%d frames dropped on the link 0-1, want 0", got)
+	}
+
+	send(nodes[1], nodes[2], broadcast)
+	if got := faults.Counts()[transport.Drop]; got != 1 {
+		t.Fatalf("%d frames dropped on the link 1-2, want 1", got)
+	}
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// MAX_FRAME_SIZE bounds a single message, results of big jobs carry a lot of
+// points.
+const MAX_FRAME_SIZE = 64 << 20
+
+// WriteFrame writes the data prefixed with its length as a big endian
+// uint32.
+func WriteFrame(w io.Writer, data []byte) error {
+	if len(data) > MAX_FRAME_SIZE {
+		return fmt.Errorf("frame of %d bytes is too big", len(data))
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("frame of %d bytes is too big", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadFrames hands every frame of the connection to handle until the peer
+// closes it or sends something that is not a frame.
+func ReadFrames(conn net.Conn, handle func(data []byte)) error {
+	defer conn.Close()
+
+	for {
+		data, err := ReadFrame(conn)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		handle(data)
+	}
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MemoryNetwork connects the memory transports of nodes running in one
+// process, connections are in-memory pipes.
+type MemoryNetwork struct {
+	mutex     sync.Mutex
+	listeners map[string]*memListener
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{listeners: make(map[string]*memListener)}
+}
+
+// NewMemoryTransport makes a transport for one node of the network.
+func NewMemoryTransport(network *MemoryNetwork) Transport {
+	return &streamTransport{
+		listen: network.listen,
+		conns:  NewConnManager(network.dial),
+	}
+}
+
+func (mn *MemoryNetwork) listen(address string) (net.Listener, error) {
+	mn.mutex.Lock()
+	defer mn.mutex.Unlock()
+
+	if _, ok := mn.listeners[address]; ok {
+		return nil, fmt.Errorf("listen memory %s: address already in use", address)
+	}
+
+	listener := &memListener{
+		network: mn,
+		address: memAddr(address),
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	mn.listeners[address] = listener
+	return listener, nil
+}
+
+func (mn *MemoryNetwork) dial(address string) (net.Conn, error) {
+	mn.mutex.Lock()
+	listener, ok := mn.listeners[address]
+	mn.mutex.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("dial memory %s: connection refused", address)
+	}
+
+	client, server := net.Pipe()
+	select {
+	case listener.conns <- memConn{server}:
+		return memConn{client}, nil
+	case <-listener.done:
+		return nil, fmt.Errorf("dial memory %s: connection refused", address)
+	case <-time.After(DIAL_TIMEOUT):
+		return nil, fmt.Errorf("dial memory %s: i/o timeout", address)
+	}
+}
+
+// memConn reports a use after Close like a socket does, so the server and
+// the connection manager tell it from a broken peer.
+type memConn struct {
+	net.Conn
+}
+
+func (mc memConn) Read(b []byte) (int, error) {
+	n, err := mc.Conn.Read(b)
+	if errors.Is(err, io.ErrClosedPipe) {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (mc memConn) Write(b []byte) (int, error) {
+	n, err := mc.Conn.Write(b)
+	if errors.Is(err, io.ErrClosedPipe) {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+type memAddr string
+
+func (ma memAddr) Network() string {
+	return "memory"
+}
+
+func (ma memAddr) String() string {
+	return string(ma)
+}
+
+type memListener struct {
+	network *MemoryNetwork
+	address memAddr
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (ml *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.conns:
+		return conn, nil
+	case <-ml.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ml *memListener) Close() error {
+	ml.once.Do(func() {
+		close(ml.done)
+
+		ml.network.mutex.Lock()
+		delete(ml.network.listeners, string(ml.address))
+		ml.network.mutex.Unlock()
+	})
+	return nil
+}
+
+func (ml *memListener) Addr() net.Addr {
+	return ml.address
+}
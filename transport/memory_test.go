@@ -0,0 +1,85 @@
+package transport_test
+
+import (
+	"distributed/transport"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const RING_SIZE = 20
+
+// TestMemoryRing passes a counter around a ring of memory transports, every
+// node sends it on to the next one over a kept connection.
+func TestMemoryRing(t *testing.T) {
+	const laps = 3
+
+	network := transport.NewMemoryNetwork()
+	done := make(chan int, 1)
+
+	address := func(i int) string {
+		return fmt.Sprintf("127.0.0.1:%d", 6300+i%RING_SIZE)
+	}
+
+	transports := make([]transport.Transport, RING_SIZE)
+	for i := range transports {
+		transports[i] = transport.NewMemoryTransport(network)
+	}
+	for i := range transports {
+		i := i
+		server, err := transports[i].Listen(address(i), func(conn net.Conn, data []byte) {
+			hops, err := strconv.Atoi(string(data))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if hops == laps*RING_SIZE {
+				done <- hops
+				return
+			}
+			if err := transports[i].Send(address(i+1), []byte(strconv.Itoa(hops+1)), true); err != nil {
+				t.Error(err)
+			}
+		}, func(conn net.Conn, err error) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go server.Serve()
+		t.Cleanup(func() {
+			server.Close()
+			transports[i].Close()
+		})
+	}
+
+	if err := transports[0].Send(address(1), []byte("1"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case hops := <-done:
+		if hops != laps*RING_SIZE {
+			t.Fatalf("%d hops, want %d", hops, laps*RING_SIZE)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the counter did not come around the ring")
+	}
+}
+
+func TestMemoryAddressInUse(t *testing.T) {
+	network := transport.NewMemoryNetwork()
+
+	first, err := transport.NewMemoryTransport(network).Listen("127.0.0.1:6300", func(conn net.Conn, data []byte) {}, func(conn net.Conn, err error) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	if _, err := transport.NewMemoryTransport(network).Listen("127.0.0.1:6300", func(conn net.Conn, data []byte) {}, func(conn net.Conn, err error) {}); err == nil {
+		t.Fatal("a second listener on the same address")
+	}
+	if err := transport.NewMemoryTransport(network).Send("127.0.0.1:6301", []byte("1"), false); err == nil {
+		t.Fatal("sent to an address nobody listens on")
+	}
+}
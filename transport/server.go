@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// MAX_HANDLERS bounds the connections served at once, kept peer connections
+// each hold one handler for as long as they are open.
+const MAX_HANDLERS = 64
+
+// Server reads frames from every accepted connection off the accept
+// goroutine. Close stops it right away by closing the listener and every
+// open connection.
+type Server struct {
+	listener net.Listener
+	handle   func(conn net.Conn, data []byte)
+	onError  func(conn net.Conn, err error)
+	slots    chan struct{}
+
+	mutex  sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewServer(listener net.Listener, handle func(conn net.Conn, data []byte), onError func(conn net.Conn, err error)) *Server {
+	return &Server{
+		listener: listener,
+		handle:   handle,
+		onError:  onError,
+		slots:    make(chan struct{}, MAX_HANDLERS),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve accepts connections until Close is called, it returns nil after a
+// Close.
+func (s *Server) Serve() error {
+	for {
+		// a full pool stops accepting, the peers wait in the backlog
+		s.slots <- struct{}{}
+
+		conn, err := s.listener.Accept()
+		if err != nil {
+			<-s.slots
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			<-s.slots
+			return nil
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+// track counts the connection in before Close can wait for it, false means
+// the server is closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() { <-s.slots }()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+	}()
+
+	err := ReadFrames(conn, func(data []byte) {
+		s.handle(conn, data)
+	})
+	if err != nil && !errors.Is(err, net.ErrClosed) && s.onError != nil {
+		s.onError(conn, err)
+	}
+}
+
+// Close stops accepting, closes every open connection and waits for their
+// handlers to return.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"net"
+)
+
+// Transport carries frames between nodes. Nodes are addressed by the
+// ip:port they are known by in the system, whatever the transport.
+type Transport interface {
+	// Send writes one frame to the node, with keep set the connection stays
+	// open for the next frames.
+	Send(address string, data []byte, keep bool) error
+	// Retain closes the kept connections to every address not in addresses.
+	Retain(addresses []string)
+	// Listen serves the frames sent to the address until the server is
+	// closed.
+	Listen(address string, handle func(conn net.Conn, data []byte), onError func(conn net.Conn, err error)) (*Server, error)
+	// Close closes every kept connection.
+	Close() error
+}
+
+// streamTransport is a Transport over any stream connections, the
+// implementations only differ in how they dial and listen.
+type streamTransport struct {
+	listen func(address string) (net.Listener, error)
+	conns  *ConnManager
+}
+
+func (st *streamTransport) Send(address string, data []byte, keep bool) error {
+	return st.conns.Send(address, data, keep)
+}
+
+func (st *streamTransport) Retain(addresses []string) {
+	st.conns.Retain(addresses)
+}
+
+func (st *streamTransport) Listen(address string, handle func(conn net.Conn, data []byte), onError func(conn net.Conn, err error)) (*Server, error) {
+	listener, err := st.listen(address)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(listener, handle, onError), nil
+}
+
+func (st *streamTransport) Close() error {
+	st.conns.Close()
+	return nil
+}
+
+func NewTCPTransport() Transport {
+	return &streamTransport{
+		listen: func(address string) (net.Listener, error) {
+			return net.Listen("tcp", address)
+		},
+		conns: NewConnManager(func(address string) (net.Conn, error) {
+			return net.DialTimeout("tcp", address, DIAL_TIMEOUT)
+		}),
+	}
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"distributed/job"
+	"distributed/node"
+)
+
+// The calls below do what the matching commands of the command line do,
+// without printing, so a worker can be driven from code.
+
+func (w *Worker) StartJob(name string) {
+	w.parseStartJob(w.ctx, name)
+}
+
+func (w *Worker) StopJob(name string) {
+	w.parseStopJob(w.ctx, name)
+}
+
+// JobStatus takes the arguments of the status command and returns the
+// status of every asked job and the nodes that did not answer in time.
+func (w *Worker) JobStatus(args string) (map[string]job.JobStatus, []node.NodeInfo) {
+	return w.collectJobStatus(w.ctx, args)
+}
+
+// JobResult takes the arguments of the result command and returns the job
+// with all of its points, ok is false when the job is not working.
+func (w *Worker) JobResult(args string) (job.Job, []node.NodeInfo, bool) {
+	return w.collectJobResult(w.ctx, args)
+}
+
+// BroadcastCounts tells how many broadcasts the worker started and
+// forwarded and how many messages they took.
+func (w *Worker) BroadcastCounts() BroadcastCounts {
+	w.BroadcastMutex.Lock()
+	defer w.BroadcastMutex.Unlock()
+
+	return w.broadcastCounts
+}
+
+// NodeState is what a worker knows about its place in the system at one
+// moment.
+type NodeState struct {
+	Joined     bool
+	Info       node.NodeInfo
+	Next       int
+	Prev       int
+	SystemInfo map[int]node.NodeInfo
+}
+
+func (w *Worker) State() NodeState {
+	w.WorkerEnterenceMutex.Lock()
+	joined := w.hasEntered
+	w.WorkerEnterenceMutex.Unlock()
+
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	systemInfo := make(map[int]node.NodeInfo, len(w.WorkerNode.SystemInfo))
+	for key, val := range w.WorkerNode.SystemInfo {
+		systemInfo[key] = val
+	}
+
+	return NodeState{
+		Joined:     joined,
+		Info:       *w.WorkerNode.GetNodeInfo(),
+		Next:       w.WorkerNode.Next,
+		Prev:       w.WorkerNode.Prev,
+		SystemInfo: systemInfo,
+	}
+}
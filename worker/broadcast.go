@@ -0,0 +1,184 @@
+package worker
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"fmt"
+	"sync"
+)
+
+// BroadcastCounts are the broadcasts a worker took part in and the
+// messages it sent for them.
+type BroadcastCounts struct {
+	Started   int
+	Forwarded int
+	TreeSent  int
+	FloodSent int
+	// tree broadcasts this worker flooded on because a tree edge failed
+	Fallbacks int
+}
+
+func (bc BroadcastCounts) Sent() int {
+	return bc.TreeSent + bc.FloodSent
+}
+
+type broadcastState struct {
+	// broadcasts of this worker go down a spanning tree of the ring, every
+	// node gets one message unless the tree breaks
+	TreeBroadcast bool
+
+	BroadcastMutex  sync.Mutex
+	broadcastCounts BroadcastCounts
+}
+
+func (w *Worker) countBroadcast(count func(counts *BroadcastCounts)) {
+	w.BroadcastMutex.Lock()
+	defer w.BroadcastMutex.Unlock()
+
+	count(&w.broadcastCounts)
+}
+
+// broadcastMessage sends a new broadcast of this node to every node. It may
+// take one hop more than a ring of every node it knows needs, for nodes
+// that entered meanwhile.
+func (w *Worker) broadcastMessage(sender *node.Worker, msg message.IMessage) bool {
+	w.countBroadcast(func(counts *BroadcastCounts) { counts.Started++ })
+
+	msgStruct, ok := msg.(*message.Message)
+	if !ok {
+		return w.floodMessage(sender, msg, "")
+	}
+	if msgStruct.TTL == 0 {
+		msgStruct.TTL = len(sender.SystemInfo) + 1
+		// copies coming back to us are duplicates
+		w.alreadyRecived(*msgStruct)
+	}
+
+	if w.TreeBroadcast {
+		msgStruct.Tree = &message.BroadcastTree{Root: sender.Id, Size: len(sender.SystemInfo)}
+		w.LogFileChan <- fmt.Sprintf("Broadcasting down the tree of %d nodes", msgStruct.Tree.Size)
+		if w.sendDownTree(sender, msgStruct) {
+			return true
+		}
+		msgStruct.Tree = nil
+		w.countBroadcast(func(counts *BroadcastCounts) { counts.Fallbacks++ })
+	}
+	return w.floodMessage(sender, msgStruct, "")
+}
+
+// forwardBroadcast sends a recived broadcast on the way it came, unless it
+// ran out of hops.
+func (w *Worker) forwardBroadcast(msgStruct message.Message) {
+	if msgStruct.TTL == 1 {
+		w.LogFileChan <- fmt.Sprintf("Recived but ain't for me: %s \\ Out of hops", msgStruct.Log())
+		return
+	}
+	w.countBroadcast(func(counts *BroadcastCounts) { counts.Forwarded++ })
+
+	sender := w.table()
+	newMsg := msgStruct.MakeMeASender(sender).(*message.Message)
+	if newMsg.Tree != nil {
+		w.LogFileChan <- fmt.Sprintf("Recived but ain't for me: %s \\ Sending down the tree", msgStruct.Log())
+		if w.sendDownTree(sender, newMsg) {
+			return
+		}
+		// flooded from here on, the subtree is reached over other routes
+		newMsg.Tree = nil
+		w.countBroadcast(func(counts *BroadcastCounts) { counts.Fallbacks++ })
+	}
+
+	from := ""
+	if msgStruct.Hop != nil {
+		from = msgStruct.Hop.GetFullAddress()
+	}
+	w.LogFileChan <- fmt.Sprintf("Recived but ain't for me: %s \\ Broadcasting", msgStruct.Log())
+	w.floodMessage(sender, newMsg, from)
+}
+
+// treeChildren returns the children of the node in the tree, ok
+// is false when the tree was made over another system than the node knows.
+func treeChildren(sender *node.Worker, msgStruct *message.Message) ([]node.NodeInfo, bool) {
+	tree := msgStruct.Tree
+	size := len(sender.SystemInfo)
+	// a node that just entered is the root of its Entered before the others
+	// know of it
+	if root, ok := sender.SystemInfo[tree.Root]; !ok || root.GetFullAddress() != msgStruct.OriginalSender.GetFullAddress() {
+		size++
+	}
+	if tree.Size != size || tree.Root < 0 || tree.Root >= size {
+		return nil, false
+	}
+
+	index := (sender.Id - tree.Root + size) % size
+	children := make([]node.NodeInfo, 0, 2)
+	for _, child := range []int{2*index + 1, 2*index + 2} {
+		if child >= size {
+			continue
+		}
+		info, ok := sender.SystemInfo[(tree.Root+child)%size]
+		if !ok {
+			return nil, false
+		}
+		children = append(children, info)
+	}
+	return children, true
+}
+
+// sendDownTree sends the message to the children of the node, false means
+// a child could not be reached and the message has to be flooded.
+func (w *Worker) sendDownTree(sender *node.Worker, msgStruct *message.Message) bool {
+	children, ok := treeChildren(sender, msgStruct)
+	if !ok {
+		w.LogErrorChan <- fmt.Sprintf("Tree of %s does not match the system, flooding", msgStruct.Log())
+		return false
+	}
+
+	result := true
+	for _, val := range children {
+		w.countBroadcast(func(counts *BroadcastCounts) { counts.TreeSent++ })
+		if !w.sendMessage(sender.GetNodeInfo(), &val, msgStruct) {
+			w.LogErrorChan <- fmt.Sprintf("Tree edge to %s failed for %s, flooding", val.String(), msgStruct.Log())
+			result = false
+		}
+	}
+	return result
+}
+
+// floodMessage sends the message once to the ring neighbours and to every
+// cluster connection, except to the node at address from.
+func (w *Worker) floodMessage(sender *node.Worker, msg message.IMessage, from string) bool {
+	result := true
+	w.LogFileChan <- fmt.Sprintf("Broadcasting to: %v", sender.SystemInfo)
+
+	targets := []node.NodeInfo{sender.SystemInfo[sender.Next], sender.SystemInfo[sender.Prev]}
+	for _, val := range sender.Connections {
+		targets = append(targets, val)
+	}
+
+	sent := map[string]bool{sender.GetFullAddress(): true, from: true}
+	for _, val := range targets {
+		address := val.GetFullAddress()
+		if sent[address] {
+			continue
+		}
+		sent[address] = true
+
+		w.countBroadcast(func(counts *BroadcastCounts) { counts.FloodSent++ })
+		if !w.sendMessage(sender.GetNodeInfo(), &val, msg) {
+			result = false
+		}
+	}
+	return result
+}
+
+func (w *Worker) parseBroadcastCounts() {
+	counts := w.BroadcastCounts()
+
+	mode := "flooding"
+	if w.TreeBroadcast {
+		mode = "spanning tree"
+	}
+	fmt.Printf("Broadcasting by %s\n", mode)
+	fmt.Printf("\tstarted: %d forwarded: %d\n", counts.Started, counts.Forwarded)
+	fmt.Printf("\tsent down the tree: %d flooded: %d fallbacks: %d\n", counts.TreeSent, counts.FloodSent, counts.Fallbacks)
+}
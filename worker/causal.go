@@ -0,0 +1,228 @@
+package worker
+
+import (
+	"distributed/message"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CAUSAL_HOLD_TIMEOUT is how long a broadcast waits for the broadcasts it
+// depends on, longer than the outbox keeps retrying them. After it the
+// missing ones are given up on.
+const CAUSAL_HOLD_TIMEOUT = 40 * time.Second
+const CAUSAL_TICK = 1 * time.Second
+
+// broadcasts that change SystemInfo are applied in causal order, a node
+// must not be updated or removed before it was entered
+func isCausal(messageType message.MessageType) bool {
+	switch messageType {
+	case message.Entered, message.UpdatedNode, message.Quit, message.NodeDead:
+		return true
+	}
+	return false
+}
+
+type heldBroadcast struct {
+	msgStruct message.Message
+	since     time.Time
+}
+
+type causalState struct {
+	CausalMutex sync.Mutex
+
+	// causal broadcasts applied from every node, keyed by full address
+	causalDelivered map[string]int64
+	causalHeld      []heldBroadcast
+	causalWake      chan struct{}
+}
+
+func (w *Worker) initCausal() {
+	w.CausalMutex.Lock()
+	w.causalDelivered = make(map[string]int64)
+	w.causalHeld = make([]heldBroadcast, 0)
+	w.CausalMutex.Unlock()
+
+	w.causalWake = make(chan struct{}, 1)
+
+	// a replay applies them as it feeds them
+	if !w.replaying {
+		go w.runCausalDelivery()
+	}
+}
+
+// causalVector returns the broadcasts applied so far, a welcomed node
+// starts from the vector of the node that welcomed it.
+func (w *Worker) causalVector() map[string]int64 {
+	w.CausalMutex.Lock()
+	defer w.CausalMutex.Unlock()
+
+	vector := make(map[string]int64, len(w.causalDelivered))
+	for key, val := range w.causalDelivered {
+		vector[key] = val
+	}
+	return vector
+}
+
+func (w *Worker) setCausalVector(vector map[string]int64) {
+	w.CausalMutex.Lock()
+	defer w.CausalMutex.Unlock()
+
+	for key, val := range vector {
+		if val > w.causalDelivered[key] {
+			w.causalDelivered[key] = val
+		}
+	}
+}
+
+// causalBroadcast numbers the broadcast after every broadcast this node
+// applied and floods it.
+func (w *Worker) causalBroadcast(msgStruct *message.Message) bool {
+	self := w.WorkerNode.GetFullAddress()
+
+	w.CausalMutex.Lock()
+	w.causalDelivered[self]++
+	msgStruct.Causal = make(map[string]int64, len(w.causalDelivered))
+	for key, val := range w.causalDelivered {
+		msgStruct.Causal[key] = val
+	}
+	w.CausalMutex.Unlock()
+
+	return w.broadcastMessage(w.table(), msgStruct)
+}
+
+// holdBroadcast keeps a recived causal broadcast until it can be applied,
+// false means it was recived before and must not be flooded again.
+func (w *Worker) holdBroadcast(msgStruct message.Message) bool {
+	origin := msgStruct.OriginalSender.GetFullAddress()
+	number := msgStruct.Causal[origin]
+
+	w.CausalMutex.Lock()
+	if number <= w.causalDelivered[origin] {
+		w.CausalMutex.Unlock()
+		return false
+	}
+	for _, held := range w.causalHeld {
+		if held.msgStruct.OriginalSender.GetFullAddress() == origin && held.msgStruct.Causal[origin] == number {
+			w.CausalMutex.Unlock()
+			return false
+		}
+	}
+	w.causalHeld = append(w.causalHeld, heldBroadcast{msgStruct: msgStruct, since: time.Now()})
+	w.CausalMutex.Unlock()
+
+	if w.replaying {
+		w.applyHeldBroadcasts()
+		return true
+	}
+	select {
+	case w.causalWake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// runCausalDelivery applies the held broadcasts one at a time, each once
+// every broadcast it depends on was applied.
+func (w *Worker) runCausalDelivery() {
+	ticker := time.NewTicker(CAUSAL_TICK)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-w.causalWake:
+		case <-ticker.C:
+		}
+
+		w.applyHeldBroadcasts()
+	}
+}
+
+// applyHeldBroadcasts applies every held broadcast that can be applied.
+func (w *Worker) applyHeldBroadcasts() {
+	for {
+		msgStruct, ok := w.nextBroadcast()
+		if !ok {
+			return
+		}
+		w.applyBroadcast(msgStruct)
+
+		origin := msgStruct.OriginalSender.GetFullAddress()
+		w.CausalMutex.Lock()
+		if msgStruct.Causal[origin] > w.causalDelivered[origin] {
+			w.causalDelivered[origin] = msgStruct.Causal[origin]
+		}
+		w.CausalMutex.Unlock()
+	}
+}
+
+// nextBroadcast takes a held broadcast that can be applied, one that waited
+// too long is taken without the broadcasts it is missing.
+func (w *Worker) nextBroadcast() (message.Message, bool) {
+	w.CausalMutex.Lock()
+	defer w.CausalMutex.Unlock()
+
+	now := time.Now()
+	for ind := 0; ind < len(w.causalHeld); ind++ {
+		held := w.causalHeld[ind]
+		origin := held.msgStruct.OriginalSender.GetFullAddress()
+
+		if held.msgStruct.Causal[origin] <= w.causalDelivered[origin] {
+			// a copy of it was applied meanwhile
+			w.causalHeld = append(w.causalHeld[:ind], w.causalHeld[ind+1:]...)
+			ind--
+			continue
+		}
+
+		missing := w.missingBroadcasts(held.msgStruct)
+		if len(missing) > 0 && now.Sub(held.since) < CAUSAL_HOLD_TIMEOUT {
+			continue
+		}
+		if len(missing) > 0 {
+			w.LogErrorChan <- fmt.Sprintf("Applying %s without the broadcasts %v it depends on", held.msgStruct.Log(), missing)
+			for key, val := range held.msgStruct.Causal {
+				if key != origin && val > w.causalDelivered[key] {
+					w.causalDelivered[key] = val
+				}
+			}
+		}
+
+		w.causalHeld = append(w.causalHeld[:ind], w.causalHeld[ind+1:]...)
+		return held.msgStruct, true
+	}
+	return message.Message{}, false
+}
+
+// missingBroadcasts lists the broadcasts from every node that must be
+// applied before the message, as node=number of the last one.
+func (w *Worker) missingBroadcasts(msgStruct message.Message) []string {
+	origin := msgStruct.OriginalSender.GetFullAddress()
+	missing := make([]string, 0)
+	for key, val := range msgStruct.Causal {
+		applied := w.causalDelivered[key]
+		if key == origin {
+			val--
+		}
+		if val > applied {
+			missing = append(missing, fmt.Sprintf("%s=%d", key, val))
+		}
+	}
+	return missing
+}
+
+func (w *Worker) applyBroadcast(msgStruct message.Message) {
+	w.LogFileChan <- "Applying broadcast " + msgStruct.Log()
+
+	switch msgStruct.MessageType {
+	case message.Entered:
+		w.proccesEnteredMessage(msgStruct)
+	case message.UpdatedNode:
+		w.proccessUpdatedNode(msgStruct)
+	case message.Quit:
+		w.proccesQuitMessage(msgStruct)
+	case message.NodeDead:
+		w.proccesNodeDead(msgStruct)
+	}
+}
@@ -0,0 +1,151 @@
+package worker
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var (
+	nodeA = node.NodeInfo{Id: 1, IpAddress: "127.0.0.1", Port: 6301}
+	nodeB = node.NodeInfo{Id: 2, IpAddress: "127.0.0.1", Port: 6302}
+)
+
+// newCausalWorker is a worker alone in its system, its held broadcasts are
+// applied by the test instead of the delivery goroutine.
+func newCausalWorker(t *testing.T) *Worker {
+	t.Helper()
+
+	w, err := NewWorker("127.0.0.1", 6300, "127.0.0.1", 7777, nil, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WorkerNode.SystemInfo[0] = *w.WorkerNode.GetNodeInfo()
+
+	w.causalDelivered = make(map[string]int64)
+	w.causalHeld = make([]heldBroadcast, 0)
+	w.causalWake = make(chan struct{}, 1)
+
+	// the logs are not written anywhere
+	go func() {
+		for {
+			select {
+			case <-w.LogFileChan:
+			case <-w.LogErrorChan:
+			}
+		}
+	}()
+	return w
+}
+
+func entered(sender node.NodeInfo, causal map[string]int64) message.Message {
+	msgStruct := *message.MakeEnteredMessage(sender)
+	msgStruct.Causal = causal
+	return msgStruct
+}
+
+// enteredA is the first broadcast of A
+func enteredA() message.Message {
+	return entered(nodeA, map[string]int64{nodeA.GetFullAddress(): 1})
+}
+
+// enteredB was sent by B after it applied the Entered of A
+func enteredB() message.Message {
+	return entered(nodeB, map[string]int64{nodeA.GetFullAddress(): 1, nodeB.GetFullAddress(): 1})
+}
+
+// addressOf is the address of the node with the id, empty if there is none
+func addressOf(w *Worker, id int) string {
+	info, ok := w.table().SystemInfo[id]
+	if !ok {
+		return ""
+	}
+	return info.GetFullAddress()
+}
+
+func TestHeldUntilItsDependencyIsApplied(t *testing.T) {
+	w := newCausalWorker(t)
+
+	if !w.holdBroadcast(enteredB()) {
+		t.Fatal("the first copy of B was taken as a duplicate")
+	}
+	w.applyHeldBroadcasts()
+	if got := len(w.table().SystemInfo); got != 1 {
+		t.Fatalf("B was applied before A, the worker knows %d nodes", got)
+	}
+	if w.holdBroadcast(enteredB()) {
+		t.Fatal("a held broadcast was held again")
+	}
+
+	w.holdBroadcast(enteredA())
+	w.applyHeldBroadcasts()
+
+	if addressOf(w, 1) != nodeA.GetFullAddress() || addressOf(w, 2) != nodeB.GetFullAddress() {
+		t.Fatalf("applied in the wrong order: %v", w.table().SystemInfo)
+	}
+	if len(w.causalHeld) != 0 {
+		t.Fatalf("still holding %d broadcasts", len(w.causalHeld))
+	}
+	if w.holdBroadcast(enteredA()) {
+		t.Fatal("an applied broadcast was held again")
+	}
+}
+
+func TestHeldTooLongIsAppliedWithoutItsDependency(t *testing.T) {
+	w := newCausalWorker(t)
+
+	w.holdBroadcast(enteredB())
+	w.causalHeld[0].since = time.Now().Add(-CAUSAL_HOLD_TIMEOUT)
+
+	msgStruct, ok := w.nextBroadcast()
+	if !ok || msgStruct.OriginalSender.GetFullAddress() != nodeB.GetFullAddress() {
+		t.Fatalf("took %v, %v", msgStruct.Log(), ok)
+	}
+	if _, ok := w.nextBroadcast(); ok {
+		t.Fatal("took a second broadcast")
+	}
+
+	// the missing broadcast is given up on, a late copy is not applied
+	if w.holdBroadcast(enteredA()) {
+		t.Fatal("A was held after B was applied without it")
+	}
+}
+
+func TestMissingBroadcasts(t *testing.T) {
+	w := newCausalWorker(t)
+	w.causalDelivered[nodeA.GetFullAddress()] = 1
+
+	msgStruct := entered(nodeB, map[string]int64{
+		nodeA.GetFullAddress(): 3,
+		nodeB.GetFullAddress(): 2,
+		// already applied
+		w.WorkerNode.GetFullAddress(): 0,
+	})
+
+	missing := w.missingBroadcasts(msgStruct)
+	sort.Strings(missing)
+	// the broadcast itself is the last of its sender
+	want := []string{"127.0.0.1:6301=3", "127.0.0.1:6302=1"}
+	if !reflect.DeepEqual(missing, want) {
+		t.Fatalf("missing %v, want %v", missing, want)
+	}
+}
+
+// an Entered sent before a removal it did not see takes the next free id,
+// like on the nodes that apply the removal after it
+func TestEnteredAfterAConcurrentRemoval(t *testing.T) {
+	w := newCausalWorker(t)
+
+	// B was given id 2 while A was still in, this worker already removed A
+	w.proccesEnteredMessage(entered(nodeB, nil))
+
+	if got := addressOf(w, 1); got != nodeB.GetFullAddress() {
+		t.Fatalf("id 1 is %q, want B", got)
+	}
+	if _, ok := w.table().SystemInfo[2]; ok {
+		t.Fatalf("B kept the id it was given: %v", w.table().SystemInfo)
+	}
+}
@@ -0,0 +1,204 @@
+package worker
+
+import (
+	"distributed/message"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ACK_TIMEOUT is how long the first send of a message waits for its ack,
+// every retry waits twice as long as the one before it.
+const ACK_TIMEOUT = 1 * time.Second
+const MAX_DELIVERY_ATTEMPTS = 5
+const OUTBOX_TICK = 250 * time.Millisecond
+
+// DEDUP_WINDOW is how long a recived message is remembered, retries of it
+// that arrive later are handled again. Broadcasts are remembered the same
+// way whatever route they took.
+const DEDUP_WINDOW = 2 * time.Minute
+
+// a message is named by the full address and incarnation of its original
+// sender and its id, ids of nodes change when the system is compacted
+type deliveryKey struct {
+	Origin      string
+	Incarnation int64
+	Id          int64
+}
+
+func keyOf(msgStruct *message.Message) deliveryKey {
+	return deliveryKey{Origin: msgStruct.OriginalSender.GetFullAddress(), Incarnation: msgStruct.OriginalSender.Incarnation, Id: msgStruct.Id}
+}
+
+// a broadcast waits for one ack from every node it was sent to
+type outboxKey struct {
+	deliveryKey
+	To string
+}
+
+type outboxEntry struct {
+	data     []byte
+	log      string
+	attempts int
+	due      time.Time
+}
+
+type deliveryState struct {
+	OutboxMutex  sync.Mutex
+	outbox       map[outboxKey]*outboxEntry
+	seenMessages map[deliveryKey]time.Time
+	// latest incarnation a broadcast came from, by full address
+	incarnations map[string]int64
+}
+
+func (w *Worker) initDelivery() {
+	w.OutboxMutex.Lock()
+	w.outbox = make(map[outboxKey]*outboxEntry)
+	w.seenMessages = make(map[deliveryKey]time.Time)
+	w.incarnations = make(map[string]int64)
+	w.OutboxMutex.Unlock()
+
+	go w.runOutbox()
+}
+
+// needsAck tells if a message is sent through the outbox. Heartbeats are
+// repeated anyway and the bootstrap does not ack.
+func (w *Worker) needsAck(address string, msgStruct *message.Message) bool {
+	switch msgStruct.MessageType {
+	case message.Ack, message.Ping, message.Pong:
+		return false
+	}
+	return address != w.BootstrapNode.GetFullAddress()
+}
+
+func (w *Worker) addToOutbox(key outboxKey, data []byte, log string) {
+	w.OutboxMutex.Lock()
+	defer w.OutboxMutex.Unlock()
+
+	w.outbox[key] = &outboxEntry{data: data, log: log, attempts: 1, due: time.Now().Add(ACK_TIMEOUT)}
+}
+
+func (w *Worker) removeFromOutbox(key outboxKey) {
+	w.OutboxMutex.Lock()
+	defer w.OutboxMutex.Unlock()
+
+	delete(w.outbox, key)
+}
+
+// runOutbox resends every message that was not acked in time and forgets
+// old recived messages.
+func (w *Worker) runOutbox() {
+	ticker := time.NewTicker(OUTBOX_TICK)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case <-w.ctx.Done():
+			return
+		case now = <-ticker.C:
+		}
+
+		resend := make(map[outboxKey][]byte)
+		givenUp := make([]string, 0)
+
+		w.OutboxMutex.Lock()
+		for key, entry := range w.outbox {
+			if now.Before(entry.due) {
+				continue
+			}
+			if entry.attempts >= MAX_DELIVERY_ATTEMPTS {
+				givenUp = append(givenUp, fmt.Sprintf("Giving up on %s to %s after %d attempts", entry.log, key.To, entry.attempts))
+				delete(w.outbox, key)
+				continue
+			}
+			entry.due = now.Add(ACK_TIMEOUT << entry.attempts)
+			entry.attempts++
+			resend[key] = entry.data
+		}
+		for key, recived := range w.seenMessages {
+			if now.Sub(recived) > DEDUP_WINDOW {
+				delete(w.seenMessages, key)
+			}
+		}
+		w.OutboxMutex.Unlock()
+
+		for _, val := range givenUp {
+			w.LogErrorChan <- val
+		}
+		for key, data := range resend {
+			w.LogFileChan <- fmt.Sprintf("No ack from %s for %s¦%d, sending again", key.To, key.Origin, key.Id)
+			if err := w.Transport.Send(key.To, data, w.isNeighbour(key.To)); err != nil {
+				w.check(err, "resend__"+key.To)
+			}
+		}
+	}
+}
+
+// alreadyRecived records the message and tells if it was recived before.
+// Broadcasts still going around from before a node restarted count as
+// recived.
+func (w *Worker) alreadyRecived(msgStruct message.Message) bool {
+	key := keyOf(&msgStruct)
+
+	w.OutboxMutex.Lock()
+	defer w.OutboxMutex.Unlock()
+
+	if msgStruct.Reciver.Id == -1 && w.staleIncarnation(key) {
+		return true
+	}
+
+	if _, ok := w.seenMessages[key]; ok {
+		return true
+	}
+	w.seenMessages[key] = time.Now()
+	return false
+}
+
+// staleIncarnation tells if the broadcast comes from an earlier incarnation
+// of its sender, the first broadcast of a new one forgets what was recived
+// from the ones before it. OutboxMutex is held.
+func (w *Worker) staleIncarnation(key deliveryKey) bool {
+	latest, ok := w.incarnations[key.Origin]
+	if ok && key.Incarnation < latest {
+		return true
+	}
+	if ok && key.Incarnation == latest {
+		return false
+	}
+
+	w.incarnations[key.Origin] = key.Incarnation
+	for seen := range w.seenMessages {
+		if seen.Origin == key.Origin && seen.Incarnation < key.Incarnation {
+			delete(w.seenMessages, seen)
+		}
+	}
+	return false
+}
+
+// sendAck acks every copy of a message, the ack of an earlier one may have
+// been lost.
+func (w *Worker) sendAck(msgStruct message.Message) {
+	hop := *msgStruct.Hop
+	toSend := message.MakeAckMessage(*w.nodeInfo(), hop, msgStruct.OriginalSender, msgStruct.Id)
+	w.sendMessage(w.nodeInfo(), &hop, toSend)
+}
+
+func (w *Worker) proccesAck(msgStruct message.Message) {
+	ackInfo, err := message.Payload[message.AckInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	w.removeFromOutbox(outboxKey{deliveryKey{Origin: ackInfo.Origin, Incarnation: ackInfo.Incarnation, Id: ackInfo.Id}, msgStruct.OriginalSender.GetFullAddress()})
+}
+
+// marshalForHop encodes the message with this node as the hop to ack.
+func (w *Worker) marshalForHop(msgStruct *message.Message) ([]byte, error) {
+	hop := *msgStruct
+	hopInfo := *w.nodeInfo()
+	hop.Hop = &hopInfo
+	return json.Marshal(&hop)
+}
@@ -0,0 +1,337 @@
+package worker
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const HEARTBEAT_INTERVAL = 2 * time.Second
+
+// SOFT_TIMEOUT is the silence after which a neighbour becomes a suspect and
+// another node is asked to double-check it.
+const SOFT_TIMEOUT = 6 * time.Second
+
+// HARD_TIMEOUT is the silence after which a neighbour is declared dead
+// even if no other node answered about it.
+const HARD_TIMEOUT = 15 * time.Second
+
+// CHECK_SUSPECT_TIMEOUT is how long a node asked to double-check a suspect
+// waits for its Pong.
+const CHECK_SUSPECT_TIMEOUT = 3 * time.Second
+
+type heartbeatState struct {
+	HeartbeatMutex      sync.Mutex
+	HeartbeatPoisonChan chan int32
+
+	// keyed by full address, ids are not stable enough to track a node
+	lastHeard map[string]time.Time
+	suspected map[string]time.Time
+	// pings waiting for their Pong, keyed by full address
+	pongWaiters map[string][]chan struct{}
+}
+
+func (w *Worker) startHeartbeat() {
+	w.HeartbeatMutex.Lock()
+	w.lastHeard = make(map[string]time.Time)
+	w.suspected = make(map[string]time.Time)
+	w.pongWaiters = make(map[string][]chan struct{})
+	w.HeartbeatMutex.Unlock()
+
+	w.HeartbeatPoisonChan = make(chan int32, 1)
+
+	go w.heartbeat(w.HeartbeatPoisonChan)
+}
+
+func (w *Worker) stopHeartbeat() {
+	if w.HeartbeatPoisonChan != nil {
+		w.HeartbeatPoisonChan <- 1
+	}
+}
+
+func (w *Worker) heartbeat(poisonChan chan int32) {
+	ticker := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-poisonChan:
+			w.LogFileChan <- "Heartbeat stopped"
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			// neighbours change as nodes come and go
+			w.Transport.Retain(w.neighbourAddresses())
+			neighbours := w.ringNeighbours()
+			w.forgetFormerNeighbours(neighbours)
+			for _, neighbour := range neighbours {
+				toSend := message.MakePingMessage(*w.nodeInfo(), neighbour)
+				w.sendMessage(w.nodeInfo(), &neighbour, toSend)
+				w.checkNeighbour(neighbour)
+			}
+		}
+	}
+}
+
+func (w *Worker) ringNeighbours() []node.NodeInfo {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	neighbours := make([]node.NodeInfo, 0, 2)
+	for _, id := range []int{w.WorkerNode.Next, w.WorkerNode.Prev} {
+		if id == w.WorkerNode.Id {
+			continue
+		}
+		neighbour, ok := w.WorkerNode.SystemInfo[id]
+		if !ok {
+			continue
+		}
+		if len(neighbours) > 0 && neighbours[0].GetFullAddress() == neighbour.GetFullAddress() {
+			continue
+		}
+		neighbours = append(neighbours, neighbour)
+	}
+	return neighbours
+}
+
+func (w *Worker) heardFrom(nodeInfo node.NodeInfo) {
+	w.HeartbeatMutex.Lock()
+	defer w.HeartbeatMutex.Unlock()
+
+	if w.lastHeard == nil {
+		return
+	}
+	w.lastHeard[nodeInfo.GetFullAddress()] = time.Now()
+	delete(w.suspected, nodeInfo.GetFullAddress())
+}
+
+func (w *Worker) forgetHeartbeat(nodeInfo node.NodeInfo) {
+	w.HeartbeatMutex.Lock()
+	defer w.HeartbeatMutex.Unlock()
+
+	delete(w.lastHeard, nodeInfo.GetFullAddress())
+	delete(w.suspected, nodeInfo.GetFullAddress())
+}
+
+// forgetFormerNeighbours drops what we heard from nodes that are no longer
+// ring neighbours, if one becomes a neighbour again its silence is counted
+// from then on.
+func (w *Worker) forgetFormerNeighbours(neighbours []node.NodeInfo) {
+	current := make(map[string]bool)
+	for _, neighbour := range neighbours {
+		current[neighbour.GetFullAddress()] = true
+	}
+
+	w.HeartbeatMutex.Lock()
+	defer w.HeartbeatMutex.Unlock()
+
+	for address := range w.lastHeard {
+		if !current[address] {
+			delete(w.lastHeard, address)
+			delete(w.suspected, address)
+		}
+	}
+}
+
+func (w *Worker) checkNeighbour(neighbour node.NodeInfo) {
+	address := neighbour.GetFullAddress()
+
+	w.HeartbeatMutex.Lock()
+	last, ok := w.lastHeard[address]
+	if !ok {
+		// new neighbour, start counting from now
+		w.lastHeard[address] = time.Now()
+		w.HeartbeatMutex.Unlock()
+		return
+	}
+	_, isSuspect := w.suspected[address]
+	silence := time.Since(last)
+	if silence > SOFT_TIMEOUT && !isSuspect {
+		w.suspected[address] = time.Now()
+	}
+	w.HeartbeatMutex.Unlock()
+
+	if silence > HARD_TIMEOUT {
+		w.LogFileChan <- fmt.Sprintf("Node %s silent for %v, hard timeout", neighbour.String(), silence)
+		w.declareDead(neighbour)
+		return
+	}
+
+	if silence > SOFT_TIMEOUT && !isSuspect {
+		w.LogFileChan <- fmt.Sprintf("Node %s silent for %v, suspecting it", neighbour.String(), silence)
+		w.askToCheck(neighbour)
+	}
+}
+
+// askToCheck asks some node other than the suspect to double-check it, the
+// other ring neighbour if there is one.
+func (w *Worker) askToCheck(suspect node.NodeInfo) {
+	var helper *node.NodeInfo
+	for _, neighbour := range w.ringNeighbours() {
+		if neighbour.GetFullAddress() != suspect.GetFullAddress() {
+			tmp := neighbour
+			helper = &tmp
+			break
+		}
+	}
+	if helper == nil {
+		w.WorkerTableMutex.Lock()
+		for _, val := range w.WorkerNode.SystemInfo {
+			if val.Id == w.WorkerNode.Id || val.GetFullAddress() == suspect.GetFullAddress() {
+				continue
+			}
+			if helper == nil || val.Id < helper.Id {
+				tmp := val
+				helper = &tmp
+			}
+		}
+		w.WorkerTableMutex.Unlock()
+	}
+	if helper == nil {
+		w.LogFileChan <- "No one to double-check suspect " + suspect.String()
+		return
+	}
+
+	toSend := message.MakeCheckSuspectMessage(*w.nodeInfo(), *helper, suspect)
+	nextNode := w.findNextNode(*helper, toSend.Route)
+	w.sendMessage(w.nodeInfo(), &nextNode, toSend)
+}
+
+func (w *Worker) declareDead(deadNode node.NodeInfo) {
+	w.forgetHeartbeat(deadNode)
+
+	w.LogFileChan <- "Declaring node dead: " + deadNode.String()
+
+	w.removeNode(deadNode)
+
+	toSend := message.MakeNodeDeadMessage(*w.nodeInfo(), deadNode)
+	w.causalBroadcast(toSend)
+
+	toSendBootstrap := message.MakeNodeDeadMessage(*w.nodeInfo(), deadNode)
+	w.sendMessage(w.nodeInfo(), w.BootstrapNode.GetNodeInfo(), toSendBootstrap)
+}
+
+func (w *Worker) proccesPing(msgStruct message.Message) {
+	w.heardFrom(msgStruct.OriginalSender)
+
+	toSend := message.MakePongMessage(*w.nodeInfo(), msgStruct.OriginalSender).AnswerTo(msgStruct)
+	w.sendMessage(w.nodeInfo(), &msgStruct.OriginalSender, toSend)
+}
+
+func (w *Worker) proccesPong(msgStruct message.Message) {
+	w.heardFrom(msgStruct.OriginalSender)
+
+	w.HeartbeatMutex.Lock()
+	defer w.HeartbeatMutex.Unlock()
+
+	address := msgStruct.OriginalSender.GetFullAddress()
+	for _, pongChan := range w.pongWaiters[address] {
+		pongChan <- struct{}{}
+	}
+	delete(w.pongWaiters, address)
+}
+
+// pingAndWait pings the node and tells if its Pong came back in timeout, a
+// node that takes the message but does not answer is not alive.
+func (w *Worker) pingAndWait(nodeInfo node.NodeInfo, timeout time.Duration) bool {
+	address := nodeInfo.GetFullAddress()
+	pongChan := make(chan struct{}, 1)
+
+	w.HeartbeatMutex.Lock()
+	if w.pongWaiters == nil {
+		w.pongWaiters = make(map[string][]chan struct{})
+	}
+	w.pongWaiters[address] = append(w.pongWaiters[address], pongChan)
+	w.HeartbeatMutex.Unlock()
+
+	defer func() {
+		w.HeartbeatMutex.Lock()
+		defer w.HeartbeatMutex.Unlock()
+
+		waiters := w.pongWaiters[address]
+		for ind, val := range waiters {
+			if val == pongChan {
+				w.pongWaiters[address] = append(waiters[:ind], waiters[ind+1:]...)
+				break
+			}
+		}
+		if len(w.pongWaiters[address]) == 0 {
+			delete(w.pongWaiters, address)
+		}
+	}()
+
+	ping := message.MakePingMessage(*w.nodeInfo(), nodeInfo)
+	if !w.sendMessage(w.nodeInfo(), &nodeInfo, ping) {
+		return false
+	}
+
+	select {
+	case <-pongChan:
+		return true
+	case <-time.After(timeout):
+		return false
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+func (w *Worker) proccesCheckSuspect(msgStruct message.Message) {
+	suspect, err := message.Payload[node.NodeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	w.LogFileChan <- fmt.Sprintf("Node %d asked me to check on %s", msgStruct.OriginalSender.Id, suspect.String())
+
+	alive := w.pingAndWait(suspect, CHECK_SUSPECT_TIMEOUT)
+
+	toSend := message.MakeSuspectStatusMessage(*w.nodeInfo(), msgStruct.OriginalSender, suspect, alive)
+	nextNode := w.findNextNode(msgStruct.OriginalSender, toSend.Route)
+	w.sendMessage(w.nodeInfo(), &nextNode, toSend)
+}
+
+func (w *Worker) proccesSuspectStatus(msgStruct message.Message) {
+	status, err := message.Payload[message.SuspectStatusInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	if status.Alive {
+		w.LogFileChan <- fmt.Sprintf("Node %d says %s is alive", msgStruct.OriginalSender.Id, status.Suspect.String())
+		w.heardFrom(status.Suspect)
+		return
+	}
+
+	w.HeartbeatMutex.Lock()
+	_, isSuspect := w.suspected[status.Suspect.GetFullAddress()]
+	w.HeartbeatMutex.Unlock()
+
+	if !isSuspect {
+		return
+	}
+
+	w.LogFileChan <- fmt.Sprintf("Node %d confirmed %s is not responding", msgStruct.OriginalSender.Id, status.Suspect.String())
+	w.declareDead(status.Suspect)
+}
+
+func (w *Worker) proccesNodeDead(msgStruct message.Message) bool {
+	deadNode, err := message.Payload[node.NodeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return false
+	}
+
+	if deadNode.GetFullAddress() == w.WorkerNode.GetFullAddress() {
+		w.LogErrorChan <- fmt.Sprintf("Node %d declared me dead", msgStruct.OriginalSender.Id)
+		return false
+	}
+
+	w.forgetHeartbeat(deadNode)
+
+	return w.removeNode(deadNode)
+}
@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"distributed/structures"
+	"fmt"
+	"sync"
+)
+
+type leaveState struct {
+	InheritedPointsMutex sync.Mutex
+
+	// points handed over by nodes that left, job name -> fractal id -> points
+	inheritedPoints map[string]map[string][]structures.Point
+}
+
+func (w *Worker) inheritPoints(jobName, fractalID string, points []structures.Point) {
+	w.InheritedPointsMutex.Lock()
+	defer w.InheritedPointsMutex.Unlock()
+
+	if w.inheritedPoints == nil {
+		w.inheritedPoints = make(map[string]map[string][]structures.Point)
+	}
+	if _, ok := w.inheritedPoints[jobName]; !ok {
+		w.inheritedPoints[jobName] = make(map[string][]structures.Point)
+	}
+	w.inheritedPoints[jobName][fractalID] = append(w.inheritedPoints[jobName][fractalID], points...)
+}
+
+func (w *Worker) inheritedPointsFor(jobName string) []structures.Point {
+	w.InheritedPointsMutex.Lock()
+	defer w.InheritedPointsMutex.Unlock()
+
+	points := make([]structures.Point, 0)
+	for _, fractalPoints := range w.inheritedPoints[jobName] {
+		points = append(points, fractalPoints...)
+	}
+	return points
+}
+
+func (w *Worker) takeInheritedPoints(jobName string) []structures.Point {
+	points := w.inheritedPointsFor(jobName)
+
+	w.InheritedPointsMutex.Lock()
+	delete(w.inheritedPoints, jobName)
+	w.InheritedPointsMutex.Unlock()
+
+	return points
+}
+
+func (w *Worker) takeInheritedFractal(jobName, fractalID string) []structures.Point {
+	w.InheritedPointsMutex.Lock()
+	defer w.InheritedPointsMutex.Unlock()
+
+	points := w.inheritedPoints[jobName][fractalID]
+	delete(w.inheritedPoints[jobName], fractalID)
+
+	if len(points) > 0 {
+		w.LogFileChan <- fmt.Sprintf("Restored %d points of %s:%s", len(points), jobName, fractalID)
+	}
+	return points
+}
+
+// clusterSiblings lists the other nodes working on our job, fractal id
+// neighbours first.
+func (w *Worker) clusterSiblings() []node.NodeInfo {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	siblings := make([]node.NodeInfo, 0)
+	seen := map[string]bool{w.WorkerNode.GetFullAddress(): true}
+
+	for _, val := range w.WorkerNode.Connections {
+		if !seen[val.GetFullAddress()] {
+			seen[val.GetFullAddress()] = true
+			siblings = append(siblings, val)
+		}
+	}
+	for _, val := range w.clusterMap {
+		if !seen[val.GetFullAddress()] {
+			seen[val.GetFullAddress()] = true
+			siblings = append(siblings, val)
+		}
+	}
+	for _, val := range w.WorkerNode.SystemInfo {
+		if len(val.JobName) > 0 && val.JobName == w.WorkerNode.JobName && !seen[val.GetFullAddress()] {
+			seen[val.GetFullAddress()] = true
+			siblings = append(siblings, val)
+		}
+	}
+
+	return siblings
+}
+
+func (w *Worker) handOffPoints() node.NodeInfo {
+	workingJob := w.runningJob()
+	points := workingJob.Points
+	points = append(points, w.takeInheritedPoints(workingJob.Name)...)
+	points = append(points, w.stopAdoptedJobs()...)
+
+	fractalID := w.nodeInfo().FractalId
+	for _, sibling := range w.clusterSiblings() {
+		toSend := message.MakeHandoffPointsMessage(*w.nodeInfo(), sibling, workingJob.Name, fractalID, points)
+		if w.sendPointMessage(&sibling, toSend) {
+			w.LogFileChan <- fmt.Sprintf("Handed %d points of %s:%s to %s", len(points), workingJob.Name, fractalID, sibling.String())
+			return sibling
+		}
+	}
+
+	w.LogErrorChan <- fmt.Sprintf("No cluster sibling took %d points of %s:%s", len(points), workingJob.Name, fractalID)
+	return node.NodeInfo{Id: -1}
+}
+
+// leaveSystem hands our points to a sibling, tells the bootstrap and the
+// rest of the system that we are leaving. The listener is closed by the caller.
+func (w *Worker) leaveSystem() {
+	w.stopHeartbeat()
+	w.stopReplication()
+
+	heir := node.NodeInfo{Id: -1}
+	if w.runningJob() != nil {
+		w.JobProccesingPoisonChan <- 1
+		heir = w.handOffPoints()
+	}
+
+	w.passTokenOnLeave()
+
+	toSendBootstrap := message.MakeLeaveMessage(*w.nodeInfo(), *w.BootstrapNode.GetNodeInfo())
+	w.sendMessage(w.nodeInfo(), w.BootstrapNode.GetNodeInfo(), toSendBootstrap)
+
+	toSend := message.MakeQuitMessage(*w.nodeInfo(), heir)
+	w.causalBroadcast(toSend)
+
+	w.LogFileChan <- "Left the system: " + w.table().String()
+}
+
+func (w *Worker) proccesHandoffPoints(msgStruct message.Message) {
+	handoff, err := message.Payload[message.HandoffInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	w.recivePointMessage(msgStruct, handoff.JobName, handoff.FractalId, len(handoff.Points))
+
+	w.LogFileChan <- fmt.Sprintf("Inherited %d points of %s:%s from %s", len(handoff.Points), handoff.JobName, handoff.FractalId, msgStruct.OriginalSender.String())
+
+	w.inheritPoints(handoff.JobName, handoff.FractalId, handoff.Points)
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"fmt"
+)
+
+// removeNode drops the node from local tables, shifts every higher id down
+// by one so ids stay contiguous and rewires Next/Prev. Every node applies the
+// same removal, so all SystemInfo maps end up the same. Returns false if the
+// node was already gone.
+func (w *Worker) removeNode(toRemove node.NodeInfo) bool {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	removedId := -1
+	for id, val := range w.WorkerNode.SystemInfo {
+		if val.GetFullAddress() == toRemove.GetFullAddress() {
+			removedId = id
+			break
+		}
+	}
+	if removedId == -1 || removedId == w.WorkerNode.Id {
+		return false
+	}
+	removedInfo := w.WorkerNode.SystemInfo[removedId]
+
+	newSystemInfo := make(map[int]node.NodeInfo)
+	for id, val := range w.WorkerNode.SystemInfo {
+		if id == removedId {
+			continue
+		}
+		newSystemInfo[compactId(id, removedId)] = compactNodeInfo(val, removedId)
+	}
+
+	w.WorkerNode.Id = compactId(w.WorkerNode.Id, removedId)
+	newSystemInfo[w.WorkerNode.Id] = *w.WorkerNode.GetNodeInfo()
+	w.WorkerNode.SystemInfo = newSystemInfo
+
+	for key, val := range w.WorkerNode.Connections {
+		if val.GetFullAddress() == toRemove.GetFullAddress() {
+			delete(w.WorkerNode.Connections, key)
+		} else {
+			w.WorkerNode.Connections[key] = compactNodeInfo(val, removedId)
+		}
+	}
+	for key, val := range w.clusterMap {
+		if val.GetFullAddress() == toRemove.GetFullAddress() {
+			delete(w.clusterMap, key)
+		} else {
+			w.clusterMap[key] = compactNodeInfo(val, removedId)
+		}
+	}
+
+	w.rewireRing()
+
+	w.LogFileChan <- fmt.Sprintf("Removed node %s, now %s, system info: %v", toRemove.String(), w.WorkerNode.String(), w.WorkerNode.SystemInfo)
+
+	go func() {
+		w.promoteReplicas(removedInfo)
+		w.recoverOrphanedWork(removedInfo)
+		w.recoverToken(removedInfo)
+	}()
+
+	return true
+}
+
+func compactId(id, removedId int) int {
+	if id > removedId {
+		return id - 1
+	}
+	return id
+}
+
+func compactNodeInfo(nodeInfo node.NodeInfo, removedId int) node.NodeInfo {
+	nodeInfo.Id = compactId(nodeInfo.Id, removedId)
+	return nodeInfo
+}
+
+// rewireRing points Next and Prev at the ring neighbours by id, the same
+// shape makeInitConnections builds when nodes join.
+func (w *Worker) rewireRing() {
+	systemSize := len(w.WorkerNode.SystemInfo)
+	if systemSize == 0 {
+		return
+	}
+
+	oldNext, oldPrev := w.WorkerNode.Next, w.WorkerNode.Prev
+
+	w.WorkerNode.Next = (w.WorkerNode.Id + 1) % systemSize
+	w.WorkerNode.Prev = (w.WorkerNode.Id - 1 + systemSize) % systemSize
+
+	if oldNext != w.WorkerNode.Next || oldPrev != w.WorkerNode.Prev {
+		w.LogFileChan <- fmt.Sprintf("Ring rewired, NEXT: %d PREV: %d", w.WorkerNode.Next, w.WorkerNode.Prev)
+	}
+}
+
+func (w *Worker) proccesQuitMessage(msgStruct message.Message) bool {
+	quitInfo, err := message.Payload[message.QuitInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return false
+	}
+
+	w.forgetHeartbeat(quitInfo.Node)
+	if quitInfo.Heir.Id != -1 {
+		// its points went to the heir, the replica would only duplicate them
+		w.dropReplicas(quitInfo.Node)
+	}
+
+	if !w.removeNode(quitInfo.Node) {
+		return false
+	}
+
+	w.LogFileChan <- fmt.Sprintf("Node %s left the system, its points are with %s", quitInfo.Node.String(), quitInfo.Heir.String())
+	return true
+}
@@ -0,0 +1,102 @@
+package worker
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"distributed/transport"
+	"encoding/json"
+	"fmt"
+	"net"
+)
+
+type peersState struct {
+	// connections to the ring neighbours and cluster connections are kept
+	// open, Start uses TCP when no transport was set
+	Transport transport.Transport
+}
+
+// nodeInfo is what the worker tells others about itself. Handlers renumber
+// and rename the node, so it is read under WorkerTableMutex.
+func (w *Worker) nodeInfo() *node.NodeInfo {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	return w.WorkerNode.GetNodeInfo()
+}
+
+// table returns a copy of the node and its tables, it can be read without
+// holding WorkerTableMutex. Every writer of the tables holds it.
+func (w *Worker) table() *node.Worker {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	table := w.WorkerNode
+	table.SystemInfo = make(map[int]node.NodeInfo, len(w.WorkerNode.SystemInfo))
+	for key, val := range w.WorkerNode.SystemInfo {
+		table.SystemInfo[key] = val
+	}
+	table.Connections = make(map[string]node.NodeInfo, len(w.WorkerNode.Connections))
+	for key, val := range w.WorkerNode.Connections {
+		table.Connections[key] = val
+	}
+	return &table
+}
+
+func (w *Worker) neighbourAddresses() []string {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	addresses := make([]string, 0, len(w.WorkerNode.Connections)+2)
+	for _, id := range []int{w.WorkerNode.Next, w.WorkerNode.Prev} {
+		if id == w.WorkerNode.Id {
+			continue
+		}
+		if neighbour, ok := w.WorkerNode.SystemInfo[id]; ok {
+			addresses = append(addresses, neighbour.GetFullAddress())
+		}
+	}
+	for _, val := range w.WorkerNode.Connections {
+		addresses = append(addresses, val.GetFullAddress())
+	}
+	return addresses
+}
+
+func (w *Worker) isNeighbour(address string) bool {
+	for _, neighbour := range w.neighbourAddresses() {
+		if neighbour == address {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFrame handles one message a peer sent, the frames of a connection
+// are handled in the order they were sent.
+func (w *Worker) handleFrame(conn net.Conn, data []byte) {
+	var msgStruct message.Message
+	if err := json.Unmarshal(data, &msgStruct); err != nil {
+		w.LogErrorChan <- fmt.Sprintf("Dropping bad message from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	if msgStruct.MessageType == message.Ack {
+		w.proccesAck(msgStruct)
+		return
+	}
+	if msgStruct.Hop != nil {
+		go w.sendAck(msgStruct)
+	}
+	// a broadcast reaches a node over every route, only the first copy is
+	// handled
+	if msgStruct.Hop != nil || msgStruct.Reciver.Id == -1 {
+		if w.alreadyRecived(msgStruct) {
+			w.LogFileChan <- "Dropping duplicate " + msgStruct.Log()
+			return
+		}
+	}
+	w.processRecivedMessage(msgStruct)
+}
+
+func (w *Worker) closedOnError(conn net.Conn, err error) {
+	w.LogErrorChan <- fmt.Sprintf("Closing connection from %s: %v", conn.RemoteAddr(), err)
+}
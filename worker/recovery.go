@@ -0,0 +1,208 @@
+package worker
+
+import (
+	"distributed/job"
+	"distributed/message"
+	"distributed/modulemath"
+	"distributed/node"
+	"distributed/structures"
+	"fmt"
+	"sync"
+)
+
+type adoptedJob struct {
+	job        *job.Job
+	poisonChan chan int32
+}
+
+type recoveryState struct {
+	AdoptedJobsMutex sync.Mutex
+
+	// orphaned fractal ids this node computes next to its own, fractal id -> job
+	adoptedJobs map[string]*adoptedJob
+}
+
+// recoverOrphanedWork runs on every node after a node is removed. All nodes
+// pick the same heir from SystemInfo, only the heir acts: an idle node takes
+// the fractal id as its own, otherwise a cluster sibling computes it next to
+// its own part.
+func (w *Worker) recoverOrphanedWork(removed node.NodeInfo) {
+	if len(removed.JobName) == 0 || len(removed.FractalId) == 0 {
+		return
+	}
+
+	jobInfo := w.knownJob(removed.JobName)
+	if jobInfo == nil || !jobInfo.Working {
+		return
+	}
+
+	heir, idle, ok := w.findOrphanHeir(removed.JobName)
+	if !ok {
+		w.LogErrorChan <- fmt.Sprintf("No node left to take over %s:%s", removed.JobName, removed.FractalId)
+		return
+	}
+
+	if heir.GetFullAddress() != w.WorkerNode.GetFullAddress() {
+		w.LogFileChan <- fmt.Sprintf("Node %s takes over orphaned %s:%s", heir.String(), removed.JobName, removed.FractalId)
+		return
+	}
+
+	if idle {
+		w.takeOverFractal(removed.JobName, removed.FractalId)
+	} else {
+		w.adoptFractal(removed.JobName, removed.FractalId)
+	}
+}
+
+// findOrphanHeir returns the idle node with the lowest id, or if there is
+// none, the lowest id node working on the job.
+func (w *Worker) findOrphanHeir(jobName string) (node.NodeInfo, bool, bool) {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	var idleHeir, siblingHeir *node.NodeInfo
+	for _, val := range w.WorkerNode.SystemInfo {
+		tmp := val
+		if len(val.JobName) == 0 {
+			if idleHeir == nil || val.Id < idleHeir.Id {
+				idleHeir = &tmp
+			}
+		} else if val.JobName == jobName {
+			if siblingHeir == nil || val.Id < siblingHeir.Id {
+				siblingHeir = &tmp
+			}
+		}
+	}
+
+	if idleHeir != nil {
+		return *idleHeir, true, true
+	}
+	if siblingHeir != nil {
+		return *siblingHeir, false, true
+	}
+	return node.NodeInfo{}, false, false
+}
+
+func (w *Worker) takeOverFractal(jobName, fractalID string) {
+	scaledJob, err := scaleJobToFractal(w.knownJob(jobName), fractalID)
+	if err != nil {
+		w.LogErrorChan <- fmt.Sprintf("Can't take over %s:%s: %v", jobName, fractalID, err)
+		return
+	}
+	w.LogFileChan <- fmt.Sprintf("Taking over orphaned %s:%s", jobName, fractalID)
+
+	scaledJob.Points = append(scaledJob.Points, w.takeInheritedFractal(jobName, fractalID)...)
+	w.JobMutex.Lock()
+	w.workingJob = scaledJob
+	w.JobMutex.Unlock()
+
+	neighbours := make([]node.NodeInfo, 0)
+	w.WorkerTableMutex.Lock()
+	w.WorkerNode.JobName = jobName
+	w.WorkerNode.FractalId = fractalID
+	w.WorkerNode.SystemInfo[w.WorkerNode.Id] = *w.WorkerNode.GetNodeInfo()
+
+	w.clusterMap = make(map[string]node.NodeInfo)
+	for _, val := range w.WorkerNode.SystemInfo {
+		if val.JobName != jobName || val.Id == w.WorkerNode.Id {
+			continue
+		}
+		w.clusterMap[val.FractalId] = val
+		if modulemath.EditDistance(fractalID, val.FractalId) == 1 {
+			neighbours = append(neighbours, val)
+		}
+	}
+	w.WorkerTableMutex.Unlock()
+
+	for _, val := range neighbours {
+		toSend := message.MakeClusterConnectionRequestMessage(*w.nodeInfo(), val)
+		nextNode := w.findNextNode(val, toSend.Route)
+		w.sendMessage(w.nodeInfo(), &nextNode, toSend)
+	}
+
+	w.updateNode()
+
+	w.LogFileChan <- "Starting job: " + scaledJob.Log()
+
+	go w.startJob(scaledJob, w.JobProccesingPoisonChan)
+
+	// the gate opens like for a node welcomed to the cluster, off the
+	// goroutine applying the removal
+	go func() {
+		w.ClusterGate <- 1
+	}()
+}
+
+func (w *Worker) adoptFractal(jobName, fractalID string) {
+	w.AdoptedJobsMutex.Lock()
+	defer w.AdoptedJobsMutex.Unlock()
+
+	if w.adoptedJobs == nil {
+		w.adoptedJobs = make(map[string]*adoptedJob)
+	}
+	if _, ok := w.adoptedJobs[fractalID]; ok {
+		return
+	}
+
+	scaledJob, err := scaleJobToFractal(w.knownJob(jobName), fractalID)
+	if err != nil {
+		w.LogErrorChan <- fmt.Sprintf("Can't adopt %s:%s: %v", jobName, fractalID, err)
+		return
+	}
+	w.LogFileChan <- fmt.Sprintf("Adopting orphaned %s:%s next to %s", jobName, fractalID, w.nodeInfo().FractalId)
+
+	adopted := &adoptedJob{job: scaledJob, poisonChan: make(chan int32)}
+	adopted.job.Points = append(adopted.job.Points, w.takeInheritedFractal(jobName, fractalID)...)
+	w.adoptedJobs[fractalID] = adopted
+
+	go w.startJob(adopted.job, adopted.poisonChan)
+}
+
+// stopAdoptedJobs stops computing every adopted fractal id and returns
+// their points.
+func (w *Worker) stopAdoptedJobs() []structures.Point {
+	w.AdoptedJobsMutex.Lock()
+	defer w.AdoptedJobsMutex.Unlock()
+
+	points := make([]structures.Point, 0)
+	for fractalID, adopted := range w.adoptedJobs {
+		adopted.poisonChan <- 1
+		w.JobMutex.Lock()
+		points = append(points, adopted.job.Points...)
+		w.JobMutex.Unlock()
+		delete(w.adoptedJobs, fractalID)
+	}
+	return points
+}
+
+func (w *Worker) adoptedPointsFor(jobName string) []structures.Point {
+	w.AdoptedJobsMutex.Lock()
+	defer w.AdoptedJobsMutex.Unlock()
+
+	w.JobMutex.Lock()
+	defer w.JobMutex.Unlock()
+
+	points := make([]structures.Point, 0)
+	for _, adopted := range w.adoptedJobs {
+		if adopted.job.Name == jobName {
+			points = append(points, adopted.job.Points...)
+		}
+	}
+	return points
+}
+
+func (w *Worker) adoptedJobStatus(jobStatus *job.JobStatus) {
+	w.AdoptedJobsMutex.Lock()
+	defer w.AdoptedJobsMutex.Unlock()
+
+	for fractalID, adopted := range w.adoptedJobs {
+		if adopted.job.Name != jobStatus.Name {
+			continue
+		}
+		w.JobMutex.Lock()
+		adoptedStatus := adopted.job.GetJobStatus(fractalID)
+		w.JobMutex.Unlock()
+		jobStatus.PointsGenerated += adoptedStatus.PointsGenerated
+		jobStatus.PointsPerNodes[fractalID] = adoptedStatus.PointsGenerated
+	}
+}
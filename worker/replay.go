@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"distributed/job"
+	"distributed/message"
+	"distributed/trace"
+	"distributed/transport"
+	"fmt"
+	"time"
+)
+
+type replayState struct {
+	// every message the worker recives and sends is written to it when set
+	Recorder *trace.Recorder
+	// replayed messages are handled one at a time
+	replaying bool
+	// connection responses the replayed join still waits for
+	replayConnections int
+	// the replayed join waits for the bootstrap to take its id
+	replayJoining bool
+}
+
+// ReplayWorker makes the worker that recorded the trace and feeds it the
+// trace again.
+func ReplayWorker(tracePath string, bootstrapIpAddres string, bootstrapPort int, jobs []job.Job, FILE_SEPARATOR string, step bool, recorder *trace.Recorder) {
+	records, ipAddres, port, err := trace.LoadNode(tracePath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	w, err := NewWorker(ipAddres, port, bootstrapIpAddres, bootstrapPort, jobs, FILE_SEPARATOR)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	w.Recorder = recorder
+
+	if err := w.Replay(context.Background(), records, step); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// handle runs the handler of a recived message in a goroutine of its own,
+// in a replay it waits for it so the messages are handled in order.
+func (w *Worker) handle(handler func(message.Message), msgStruct message.Message) {
+	if !trace.Handle(w.replaying, handler, msgStruct) {
+		w.LogErrorChan <- fmt.Sprintf("Replaying on while %s is still handled", msgStruct.Log())
+	}
+}
+
+// Replay feeds the recived messages of a trace to the worker in the order
+// they were recived, with the random numbers seeded as in the recorded run.
+// Nothing is sent, the messages the worker would send can be recorded, and
+// the logs go next to the recorded ones. With step set it waits for enter
+// before every message.
+func (w *Worker) Replay(ctx context.Context, records []trace.Record, step bool) error {
+	w.replaying = true
+	w.logPath = trace.ReplayPath(w.logPath)
+	w.errorPath = trace.ReplayPath(w.errorPath)
+	w.Transport = transport.NewDiscardTransport()
+	if err := w.setUp(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		time.Sleep(trace.REPLAY_SETTLE)
+		w.cancel()
+		w.Recorder.Close()
+		close(w.stopped)
+	}()
+
+	return trace.Play(records, step, func(msgStruct message.Message) {
+		w.processRecivedMessage(msgStruct)
+		w.replayEntered(msgStruct)
+	})
+}
+
+// replayEntered stands in for the join Start makes once the worker was
+// welcomed, only the connection responses and the answer of the bootstrap
+// it waits for are replayed. The timers are left out, a replay only goes as
+// far as its messages.
+func (w *Worker) replayEntered(msgStruct message.Message) {
+	select {
+	case <-w.WorkerEnteredChannel:
+		if len(w.table().SystemInfo) > 1 {
+			w.ConnectionWaitGroup.Add(2)
+			w.replayConnections = 2
+			return
+		}
+		w.replayJoining = true
+		return
+	default:
+	}
+
+	switch {
+	case w.replayConnections > 0 && msgStruct.MessageType == message.ConnectionResponse:
+		w.replayConnections--
+		if w.replayConnections == 0 {
+			w.ConnectionWaitGroup.Wait()
+			w.replayJoining = true
+		}
+	case w.replayJoining && msgStruct.MessageType == message.JoinResponse:
+		w.replayJoining = false
+		if <-w.JoinResponseChan {
+			w.announceEntered()
+		} else {
+			w.resetEntrance()
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package worker
+
+import (
+	"distributed/job"
+	"distributed/message"
+	"distributed/node"
+	"distributed/structures"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const REPLICATION_INTERVAL = 3 * time.Second
+
+type replica struct {
+	owner     node.NodeInfo
+	jobName   string
+	fractalID string
+	points    []structures.Point
+}
+
+type replicationState struct {
+	ReplicationMutex      sync.Mutex
+	ReplicationPoisonChan chan int32
+
+	// replicas kept for other nodes, keyed by owner address and fractal id
+	replicas map[string]*replica
+
+	// how many points of each of our jobs the buddy already has
+	replicatedCount  map[string]int
+	replicationBuddy string
+}
+
+func replicaKey(owner node.NodeInfo, fractalID string) string {
+	return owner.GetFullAddress() + "¦" + fractalID
+}
+
+func (w *Worker) startReplication() {
+	w.ReplicationMutex.Lock()
+	w.replicas = make(map[string]*replica)
+	w.replicatedCount = make(map[string]int)
+	w.replicationBuddy = ""
+	w.ReplicationMutex.Unlock()
+
+	w.ReplicationPoisonChan = make(chan int32, 1)
+
+	go w.replicatePoints(w.ReplicationPoisonChan)
+}
+
+func (w *Worker) stopReplication() {
+	if w.ReplicationPoisonChan != nil {
+		w.ReplicationPoisonChan <- 1
+	}
+}
+
+func (w *Worker) replicatePoints(poisonChan chan int32) {
+	ticker := time.NewTicker(REPLICATION_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-poisonChan:
+			w.LogFileChan <- "Replication stopped"
+			return
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			w.pushReplicas()
+		}
+	}
+}
+
+// findBuddy picks the fractal id neighbour with the lowest id, then any node
+// on the same job and the ring successor when we are alone on the job.
+func (w *Worker) findBuddy() (node.NodeInfo, bool) {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	var buddy *node.NodeInfo
+	for _, val := range w.WorkerNode.Connections {
+		tmp := val
+		if buddy == nil || val.Id < buddy.Id {
+			buddy = &tmp
+		}
+	}
+	if buddy == nil {
+		for _, val := range w.WorkerNode.SystemInfo {
+			tmp := val
+			if val.Id == w.WorkerNode.Id || len(val.JobName) == 0 || val.JobName != w.WorkerNode.JobName {
+				continue
+			}
+			if buddy == nil || val.Id < buddy.Id {
+				buddy = &tmp
+			}
+		}
+	}
+	if buddy == nil && w.WorkerNode.Next != w.WorkerNode.Id {
+		if next, ok := w.WorkerNode.SystemInfo[w.WorkerNode.Next]; ok {
+			buddy = &next
+		}
+	}
+	if buddy == nil {
+		return node.NodeInfo{}, false
+	}
+	return *buddy, true
+}
+
+func (w *Worker) pushReplicas() {
+	workingJob := w.runningJob()
+	if workingJob == nil {
+		return
+	}
+
+	buddy, ok := w.findBuddy()
+	if !ok {
+		return
+	}
+
+	w.ReplicationMutex.Lock()
+	if w.replicationBuddy != buddy.GetFullAddress() {
+		w.LogFileChan <- "Replicating points to buddy " + buddy.String()
+		w.replicationBuddy = buddy.GetFullAddress()
+		w.replicatedCount = make(map[string]int)
+	}
+	w.ReplicationMutex.Unlock()
+
+	// copies, startJob keeps adding points while they are sent
+	toReplicate := map[string]*job.Job{w.nodeInfo().FractalId: workingJob}
+	w.AdoptedJobsMutex.Lock()
+	w.JobMutex.Lock()
+	for fractalID, adopted := range w.adoptedJobs {
+		toReplicate[fractalID] = copyJob(adopted.job)
+	}
+	w.JobMutex.Unlock()
+	w.AdoptedJobsMutex.Unlock()
+
+	for fractalID, jobInput := range toReplicate {
+		w.pushJobReplica(buddy, fractalID, jobInput)
+	}
+}
+
+func (w *Worker) pushJobReplica(buddy node.NodeInfo, fractalID string, jobInput *job.Job) {
+	points := jobInput.Points
+	countKey := jobInput.Name + "¦" + fractalID
+
+	w.ReplicationMutex.Lock()
+	sent, ok := w.replicatedCount[countKey]
+	w.ReplicationMutex.Unlock()
+
+	reset := !ok || sent > len(points)
+	if reset {
+		sent = 0
+	} else if sent == len(points) {
+		return
+	}
+
+	newPoints := make([]structures.Point, 0, len(points)-sent)
+	newPoints = append(newPoints, points[sent:]...)
+
+	toSend := message.MakeReplicaPointsMessage(*w.nodeInfo(), buddy, jobInput.Name, fractalID, newPoints, reset)
+	if w.sendMessage(w.nodeInfo(), &buddy, toSend) {
+		w.ReplicationMutex.Lock()
+		w.replicatedCount[countKey] = len(points)
+		w.ReplicationMutex.Unlock()
+	}
+}
+
+// resetReplication makes the next push resend everything, used when the
+// points of the working job are rescaled.
+func (w *Worker) resetReplication() {
+	w.ReplicationMutex.Lock()
+	defer w.ReplicationMutex.Unlock()
+
+	w.replicatedCount = make(map[string]int)
+}
+
+func (w *Worker) proccesReplicaPoints(msgStruct message.Message) {
+	replicaInfo, err := message.Payload[message.ReplicaInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	w.ReplicationMutex.Lock()
+	defer w.ReplicationMutex.Unlock()
+
+	key := replicaKey(msgStruct.OriginalSender, replicaInfo.FractalId)
+	rep, ok := w.replicas[key]
+	if !ok || replicaInfo.Reset || rep.jobName != replicaInfo.JobName {
+		rep = &replica{jobName: replicaInfo.JobName, fractalID: replicaInfo.FractalId, points: make([]structures.Point, 0)}
+		w.replicas[key] = rep
+	}
+	rep.owner = msgStruct.OriginalSender
+	rep.points = append(rep.points, replicaInfo.Points...)
+}
+
+// promoteReplicas turns the replicas of a removed node into inherited
+// points, so result still draws them.
+func (w *Worker) promoteReplicas(removed node.NodeInfo) {
+	w.ReplicationMutex.Lock()
+	defer w.ReplicationMutex.Unlock()
+
+	for key, rep := range w.replicas {
+		if rep.owner.GetFullAddress() != removed.GetFullAddress() {
+			continue
+		}
+		delete(w.replicas, key)
+		w.LogFileChan <- fmt.Sprintf("Promoting replica of %d points of %s:%s from %s", len(rep.points), rep.jobName, rep.fractalID, removed.String())
+		w.inheritPoints(rep.jobName, rep.fractalID, rep.points)
+	}
+}
+
+func (w *Worker) dropReplicas(owner node.NodeInfo) {
+	w.ReplicationMutex.Lock()
+	defer w.ReplicationMutex.Unlock()
+
+	for key, rep := range w.replicas {
+		if rep.owner.GetFullAddress() == owner.GetFullAddress() {
+			delete(w.replicas, key)
+		}
+	}
+}
+
+func (w *Worker) clearReplicas() {
+	w.ReplicationMutex.Lock()
+	defer w.ReplicationMutex.Unlock()
+
+	w.replicas = make(map[string]*replica)
+	w.replicatedCount = make(map[string]int)
+}
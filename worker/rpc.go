@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"context"
+	"distributed/message"
+	"distributed/node"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type rpcState struct {
+	// QueryTimeout is how long a call waits for the asked nodes, whatever
+	// arrived by then is used.
+	QueryTimeout time.Duration
+	RpcMutex     sync.Mutex
+
+	// reply channel of every call in flight, keyed by its request id
+	pendingCalls map[int64]chan message.Message
+
+	// the last StopShareJob of every reorganizer, keyed by its address. A
+	// repeat of it is answered again instead of stopping the job twice.
+	stopShareAnswers map[string]*stopShareAnswer
+}
+
+type stopShareAnswer struct {
+	requestId int64
+	// nil until the job is stopped
+	reply *message.Message
+}
+
+func (w *Worker) initRpc() {
+	w.RpcMutex.Lock()
+	defer w.RpcMutex.Unlock()
+
+	w.pendingCalls = make(map[int64]chan message.Message)
+	w.stopShareAnswers = make(map[string]*stopShareAnswer)
+}
+
+func newRequestId() int64 {
+	return int64(message.MainCounter.Inc())
+}
+
+// callNodes sends the request made by makeRequest to every node and waits
+// for their replies until all of them answered, ctx is done or QueryTimeout
+// passed. It returns the replies and the nodes that did not answer.
+func (w *Worker) callNodes(ctx context.Context, nodes []node.NodeInfo, makeRequest func(reciver node.NodeInfo) *message.Message) ([]message.Message, []node.NodeInfo) {
+	return w.callNodesRetrying(ctx, nodes, 0, makeRequest)
+}
+
+// callNodesRetrying is callNodes that asks the nodes that did not answer in
+// QueryTimeout again, up to retries times. A retry sends the same message
+// again, so an answer to an earlier send that comes late still counts and the
+// node can tell a repeat from a new request.
+func (w *Worker) callNodesRetrying(ctx context.Context, nodes []node.NodeInfo, retries int, makeRequest func(reciver node.NodeInfo) *message.Message) ([]message.Message, []node.NodeInfo) {
+	requestId := newRequestId()
+	// a node can answer every send
+	replies := make(chan message.Message, len(nodes)*(retries+1))
+
+	w.RpcMutex.Lock()
+	w.pendingCalls[requestId] = replies
+	w.RpcMutex.Unlock()
+
+	defer func() {
+		w.RpcMutex.Lock()
+		delete(w.pendingCalls, requestId)
+		w.RpcMutex.Unlock()
+	}()
+
+	pending := make(map[string]node.NodeInfo)
+	for _, reciver := range nodes {
+		pending[reciver.GetFullAddress()] = reciver
+	}
+
+	requests := make(map[string]*message.Message, len(nodes))
+	answers := make([]message.Message, 0, len(nodes))
+	for attempt := 0; attempt <= retries && len(pending) > 0; attempt++ {
+		asked := make([]node.NodeInfo, 0, len(pending))
+		for _, val := range pending {
+			asked = append(asked, val)
+		}
+		sort.Slice(asked, func(i, j int) bool { return asked[i].Id < asked[j].Id })
+		if attempt > 0 {
+			w.LogFileChan <- fmt.Sprintf("Request %d: asking %s again", requestId, describeMissing(asked))
+		}
+
+		for _, reciver := range asked {
+			msg, ok := requests[reciver.GetFullAddress()]
+			if !ok {
+				msg = makeRequest(reciver)
+				msg.RequestId = requestId
+				requests[reciver.GetFullAddress()] = msg
+			}
+			nextNode := w.findNextNode(reciver, msg.Route)
+			w.sendMessage(w.nodeInfo(), &nextNode, msg)
+		}
+
+		answers = w.awaitReplies(ctx, replies, pending, answers)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(pending) == 0 {
+		return answers, nil
+	}
+
+	missing := make([]node.NodeInfo, 0, len(pending))
+	for _, val := range pending {
+		missing = append(missing, val)
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Id < missing[j].Id })
+
+	w.LogErrorChan <- fmt.Sprintf("Request %d: no answer from %s", requestId, describeMissing(missing))
+	return answers, missing
+}
+
+// awaitReplies takes replies of the pending nodes off pending until all of
+// them answered, ctx is done or QueryTimeout passed.
+func (w *Worker) awaitReplies(ctx context.Context, replies chan message.Message, pending map[string]node.NodeInfo, answers []message.Message) []message.Message {
+	ctx, cancel := context.WithTimeout(ctx, w.QueryTimeout)
+	defer cancel()
+
+	for len(pending) > 0 {
+		select {
+		case reply := <-replies:
+			address := reply.OriginalSender.GetFullAddress()
+			if _, ok := pending[address]; !ok {
+				continue
+			}
+			delete(pending, address)
+			answers = append(answers, reply)
+		case <-ctx.Done():
+			return answers
+		}
+	}
+	return answers
+}
+
+// claimStopShare tells if the StopShareJob is new, otherwise it returns the
+// answer already sent to it, nil if it is still being handled.
+func (w *Worker) claimStopShare(msgStruct message.Message) (*message.Message, bool) {
+	w.RpcMutex.Lock()
+	defer w.RpcMutex.Unlock()
+
+	address := msgStruct.OriginalSender.GetFullAddress()
+	if answer, ok := w.stopShareAnswers[address]; ok && answer.requestId == msgStruct.RequestId {
+		return answer.reply, false
+	}
+	w.stopShareAnswers[address] = &stopShareAnswer{requestId: msgStruct.RequestId}
+	return nil, true
+}
+
+func (w *Worker) answeredStopShare(msgStruct message.Message, reply *message.Message) {
+	w.RpcMutex.Lock()
+	defer w.RpcMutex.Unlock()
+
+	if answer, ok := w.stopShareAnswers[msgStruct.OriginalSender.GetFullAddress()]; ok && answer.requestId == msgStruct.RequestId {
+		answer.reply = reply
+	}
+}
+
+// deliverReply hands the reply to the call waiting for it, replies that
+// come after their call ended are dropped.
+func (w *Worker) deliverReply(msgStruct message.Message) {
+	w.RpcMutex.Lock()
+	replies, ok := w.pendingCalls[msgStruct.RequestId]
+	w.RpcMutex.Unlock()
+
+	if !ok {
+		w.LogFileChan <- fmt.Sprintf("Dropping late reply %s to request %d", msgStruct.Log(), msgStruct.RequestId)
+		return
+	}
+
+	select {
+	case replies <- msgStruct:
+	default:
+		w.LogErrorChan <- fmt.Sprintf("Dropping unexpected reply %s to request %d", msgStruct.Log(), msgStruct.RequestId)
+	}
+}
+
+// describeMissing lists the fractal ids of the nodes, nodes without a job
+// are listed by id and address.
+func describeMissing(missing []node.NodeInfo) string {
+	names := make([]string, 0, len(missing))
+	for _, val := range missing {
+		if len(val.FractalId) > 0 {
+			names = append(names, val.FractalId)
+		} else {
+			names = append(names, val.String())
+		}
+	}
+	return strings.Join(names, ", ")
+}
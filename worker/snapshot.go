@@ -0,0 +1,411 @@
+package worker
+
+import (
+	"distributed/message"
+	"distributed/node"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshots follow Lai-Yang, messages are not FIFO since they are relayed
+// over different routes, the outbox sends them again and every message is
+// handled on a goroutine of its own. Point carrying messages are colored with the latest
+// snapshot their sender recorded, a node records its state before it handles
+// a message with a newer color, and older colored messages that arrive after
+// the recording are the channel state. Markers only spread the snapshot over
+// the ring and cluster connections and tell nodes who to report to.
+
+const SNAPSHOT_TIMEOUT = 20 * time.Second
+
+// IN_FLIGHT is the fractal id under which points of channels with no fractal
+// id are printed
+const IN_FLIGHT = "in flight"
+
+// REORGANIZED is the fractal id under which the points a reorganizer
+// collected from the stopped nodes are printed
+const REORGANIZED = "reorganized"
+
+type snapshotState struct {
+	SnapshotMutex sync.Mutex
+
+	// latest snapshot recorded by this node, also the color of sent messages
+	recordedSnapshot    int64
+	recordedState       message.SnapshotReportInfo
+	reportedSnapshot    int64
+	snapshotInitiator   node.NodeInfo
+	pendingChannelState []message.SnapshotChannelInfo
+
+	// point carrying messages per node address, counted from the start
+	sentPointMessages    map[string]int
+	recivedPointMessages map[string]int
+	collection           *snapshotCollection
+}
+
+type snapshotCollection struct {
+	snapshotId int64
+	expected   map[string]node.NodeInfo
+	reports    map[string]message.SnapshotReportInfo
+	channels   []message.SnapshotChannelInfo
+	done       chan int32
+	finished   bool
+}
+
+func (w *Worker) initSnapshot() {
+	w.SnapshotMutex.Lock()
+	defer w.SnapshotMutex.Unlock()
+
+	w.recordedSnapshot = 0
+	w.reportedSnapshot = 0
+	w.pendingChannelState = make([]message.SnapshotChannelInfo, 0)
+	w.sentPointMessages = make(map[string]int)
+	w.recivedPointMessages = make(map[string]int)
+	w.collection = nil
+}
+
+// sendPointMessage colors a message that moves points and counts it for the
+// snapshot channel state.
+// The message is counted before it is sent, so a snapshot recorded while it
+// is on its way counts it together with its color.
+func (w *Worker) sendPointMessage(nextNode *node.NodeInfo, msg *message.Message) bool {
+	reciver := msg.Reciver.GetFullAddress()
+
+	w.SnapshotMutex.Lock()
+	color := w.recordedSnapshot
+	msg.Snapshot = color
+	w.sentPointMessages[reciver]++
+	w.SnapshotMutex.Unlock()
+
+	sent := w.sendMessage(w.nodeInfo(), nextNode, msg)
+	if !sent {
+		w.SnapshotMutex.Lock()
+		w.sentPointMessages[reciver]--
+		if w.recordedSnapshot != color {
+			w.LogErrorChan <- fmt.Sprintf("Snapshot %d counts a message to %s that was not sent", w.recordedSnapshot, reciver)
+		}
+		w.SnapshotMutex.Unlock()
+	}
+	return sent
+}
+
+// recivePointMessage is called before a point carrying message is handled.
+func (w *Worker) recivePointMessage(msgStruct message.Message, jobName, fractalID string, points int) {
+	w.SnapshotMutex.Lock()
+	defer w.SnapshotMutex.Unlock()
+
+	if msgStruct.Snapshot > w.recordedSnapshot {
+		w.recordSnapshot(msgStruct.Snapshot)
+	}
+
+	sender := msgStruct.OriginalSender.GetFullAddress()
+	w.recivedPointMessages[sender]++
+
+	if msgStruct.Snapshot >= w.recordedSnapshot {
+		return
+	}
+
+	if len(fractalID) == 0 {
+		fractalID = IN_FLIGHT
+	}
+	channelInfo := message.SnapshotChannelInfo{SnapshotId: w.recordedSnapshot, From: sender, To: w.WorkerNode.GetFullAddress(), JobName: jobName, FractalId: fractalID, Points: points}
+	w.LogFileChan <- fmt.Sprintf("Snapshot %d channel state: %d points of %s from %s", w.recordedSnapshot, points, jobName, sender)
+
+	if w.reportedSnapshot == w.recordedSnapshot {
+		w.sendSnapshotChannel(channelInfo)
+	} else {
+		w.pendingChannelState = append(w.pendingChannelState, channelInfo)
+	}
+}
+
+// recordSnapshot saves the local point counts and message counters, the
+// caller holds SnapshotMutex.
+func (w *Worker) recordSnapshot(snapshotId int64) {
+	w.recordedSnapshot = snapshotId
+	w.pendingChannelState = make([]message.SnapshotChannelInfo, 0)
+
+	w.recordedState = message.SnapshotReportInfo{
+		SnapshotId: snapshotId,
+		Node:       *w.nodeInfo(),
+		Points:     w.localPointCounts(),
+		Sent:       copyCounter(w.sentPointMessages),
+		Recived:    copyCounter(w.recivedPointMessages),
+	}
+
+	w.LogFileChan <- fmt.Sprintf("Recorded snapshot %d: %v", snapshotId, w.recordedState.Points)
+}
+
+func (w *Worker) localPointCounts() map[string]map[string]int {
+	counts := make(map[string]map[string]int)
+	add := func(jobName, fractalID string, points int) {
+		if _, ok := counts[jobName]; !ok {
+			counts[jobName] = make(map[string]int)
+		}
+		counts[jobName][fractalID] += points
+	}
+
+	// the job name is cleared before a stopped job sends its points away
+	self := w.nodeInfo()
+	if workingJob := w.runningJob(); workingJob != nil && len(self.JobName) > 0 && workingJob.Name == self.JobName {
+		add(workingJob.Name, self.FractalId, len(workingJob.Points))
+	}
+
+	w.AdoptedJobsMutex.Lock()
+	w.JobMutex.Lock()
+	for fractalID, adopted := range w.adoptedJobs {
+		add(adopted.job.Name, fractalID, len(adopted.job.Points))
+	}
+	// ReorganizeSystem keeps what the stopped nodes sent it
+	for _, known := range w.allJobs {
+		if known.Working && len(known.Points) > 0 {
+			add(known.Name, REORGANIZED, len(known.Points))
+		}
+	}
+	w.JobMutex.Unlock()
+	w.AdoptedJobsMutex.Unlock()
+
+	w.InheritedPointsMutex.Lock()
+	for jobName, fractals := range w.inheritedPoints {
+		for fractalID, points := range fractals {
+			add(jobName, fractalID, len(points))
+		}
+	}
+	w.InheritedPointsMutex.Unlock()
+
+	return counts
+}
+
+func copyCounter(counter map[string]int) map[string]int {
+	counterCopy := make(map[string]int)
+	for key, val := range counter {
+		counterCopy[key] = val
+	}
+	return counterCopy
+}
+
+func (w *Worker) sendToAddress(nodeInfo node.NodeInfo, makeMessage func(reciver node.NodeInfo) *message.Message) {
+	reciver, ok := w.findNodeByAddress(nodeInfo.GetFullAddress())
+	if !ok {
+		reciver = nodeInfo
+	}
+	if reciver.GetFullAddress() == w.WorkerNode.GetFullAddress() {
+		return
+	}
+	toSend := makeMessage(reciver)
+	go w.sendMessage(w.nodeInfo(), &reciver, toSend)
+}
+
+// sendSnapshotChannel reports channel state to the initiator, the caller
+// holds SnapshotMutex.
+func (w *Worker) sendSnapshotChannel(channelInfo message.SnapshotChannelInfo) {
+	if w.snapshotInitiator.GetFullAddress() == w.WorkerNode.GetFullAddress() {
+		go w.addSnapshotChannel(channelInfo)
+		return
+	}
+	w.sendToAddress(w.snapshotInitiator, func(reciver node.NodeInfo) *message.Message {
+		return message.MakeSnapshotChannelMessage(*w.nodeInfo(), reciver, channelInfo)
+	})
+}
+
+func (w *Worker) parseSnapshot() {
+	snapshotId := time.Now().UnixMilli()
+
+	expected := make(map[string]node.NodeInfo)
+	for _, val := range w.table().SystemInfo {
+		expected[val.GetFullAddress()] = val
+	}
+
+	w.SnapshotMutex.Lock()
+	if snapshotId <= w.recordedSnapshot {
+		snapshotId = w.recordedSnapshot + 1
+	}
+	snapshotCollected := &snapshotCollection{
+		snapshotId: snapshotId,
+		expected:   expected,
+		reports:    make(map[string]message.SnapshotReportInfo),
+		channels:   make([]message.SnapshotChannelInfo, 0),
+		done:       make(chan int32, 1),
+	}
+	w.collection = snapshotCollected
+
+	w.recordSnapshot(snapshotId)
+	w.reportedSnapshot = snapshotId
+	w.snapshotInitiator = *w.nodeInfo()
+	ownReport := w.recordedState
+	w.SnapshotMutex.Unlock()
+
+	w.LogFileChan <- fmt.Sprintf("Starting snapshot %d", snapshotId)
+
+	toSend := message.MakeSnapshotMarkerMessage(*w.nodeInfo(), snapshotId)
+	w.broadcastMessage(w.table(), toSend)
+
+	w.addSnapshotReport(ownReport)
+
+	complete := true
+	select {
+	case <-snapshotCollected.done:
+	case <-time.After(SNAPSHOT_TIMEOUT):
+		complete = false
+	}
+
+	w.SnapshotMutex.Lock()
+	snapshotCollected.finished = true
+	w.SnapshotMutex.Unlock()
+
+	w.printSnapshot(snapshotCollected, complete)
+}
+
+func (w *Worker) printSnapshot(snapshotCollected *snapshotCollection, complete bool) {
+	w.SnapshotMutex.Lock()
+	defer w.SnapshotMutex.Unlock()
+
+	totals := make(map[string]map[string]int)
+	add := func(jobName, fractalID string, points int) {
+		if _, ok := totals[jobName]; !ok {
+			totals[jobName] = make(map[string]int)
+		}
+		totals[jobName][fractalID] += points
+	}
+
+	for _, report := range snapshotCollected.reports {
+		for jobName, fractals := range report.Points {
+			for fractalID, points := range fractals {
+				add(jobName, fractalID, points)
+			}
+		}
+	}
+	for _, channelInfo := range snapshotCollected.channels {
+		add(channelInfo.JobName, channelInfo.FractalId, channelInfo.Points)
+	}
+
+	fmt.Printf("Snapshot %d of %d nodes\n", snapshotCollected.snapshotId, len(snapshotCollected.reports))
+	if !complete {
+		for address, val := range snapshotCollected.expected {
+			if _, ok := snapshotCollected.reports[address]; !ok {
+				fmt.Printf("No report from %s\n", val.String())
+			}
+		}
+		fmt.Printf("%d point messages still in flight, totals may be short\n", pendingPointMessages(snapshotCollected))
+	}
+	if len(totals) == 0 {
+		fmt.Println("No points in the system")
+	}
+
+	jobNames := make([]string, 0, len(totals))
+	for jobName := range totals {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Strings(jobNames)
+
+	for _, jobName := range jobNames {
+		fractalIDs := make([]string, 0, len(totals[jobName]))
+		jobTotal := 0
+		for fractalID, points := range totals[jobName] {
+			fractalIDs = append(fractalIDs, fractalID)
+			jobTotal += points
+		}
+		sort.Strings(fractalIDs)
+
+		fmt.Printf("Job %s has %d points\n", jobName, jobTotal)
+		for _, fractalID := range fractalIDs {
+			fmt.Printf("\t %s] %d\n", fractalID, totals[jobName][fractalID])
+		}
+	}
+	fmt.Println("-----------------------------")
+}
+
+// pendingPointMessages counts point messages sent before the snapshot that
+// neither the recorded state nor the channel state has seen yet, the caller
+// holds SnapshotMutex.
+func pendingPointMessages(snapshotCollected *snapshotCollection) int {
+	pending := 0
+	for from, report := range snapshotCollected.reports {
+		for to, sent := range report.Sent {
+			toReport, ok := snapshotCollected.reports[to]
+			if !ok {
+				continue
+			}
+			pending += sent - toReport.Recived[from]
+		}
+	}
+	return pending - len(snapshotCollected.channels)
+}
+
+func checkSnapshotDone(snapshotCollected *snapshotCollection) {
+	if snapshotCollected.finished || len(snapshotCollected.reports) < len(snapshotCollected.expected) {
+		return
+	}
+	if pendingPointMessages(snapshotCollected) > 0 {
+		return
+	}
+	snapshotCollected.finished = true
+	snapshotCollected.done <- 1
+}
+
+func (w *Worker) addSnapshotReport(report message.SnapshotReportInfo) {
+	w.SnapshotMutex.Lock()
+	defer w.SnapshotMutex.Unlock()
+
+	if w.collection == nil || w.collection.snapshotId != report.SnapshotId {
+		return
+	}
+	w.collection.reports[report.Node.GetFullAddress()] = report
+	checkSnapshotDone(w.collection)
+}
+
+func (w *Worker) addSnapshotChannel(channelInfo message.SnapshotChannelInfo) {
+	w.SnapshotMutex.Lock()
+	defer w.SnapshotMutex.Unlock()
+
+	if w.collection == nil || w.collection.snapshotId != channelInfo.SnapshotId {
+		return
+	}
+	w.collection.channels = append(w.collection.channels, channelInfo)
+	checkSnapshotDone(w.collection)
+}
+
+func (w *Worker) proccesSnapshotMarker(msgStruct message.Message) {
+	snapshotId := msgStruct.Snapshot
+
+	w.SnapshotMutex.Lock()
+	defer w.SnapshotMutex.Unlock()
+
+	if snapshotId < w.recordedSnapshot || snapshotId == w.reportedSnapshot {
+		return
+	}
+	if snapshotId > w.recordedSnapshot {
+		w.recordSnapshot(snapshotId)
+	}
+	w.reportedSnapshot = snapshotId
+	w.snapshotInitiator = msgStruct.OriginalSender
+
+	report := w.recordedState
+	w.sendToAddress(w.snapshotInitiator, func(reciver node.NodeInfo) *message.Message {
+		return message.MakeSnapshotReportMessage(*w.nodeInfo(), reciver, report)
+	})
+
+	for _, channelInfo := range w.pendingChannelState {
+		w.sendSnapshotChannel(channelInfo)
+	}
+	w.pendingChannelState = make([]message.SnapshotChannelInfo, 0)
+}
+
+func (w *Worker) proccesSnapshotReport(msgStruct message.Message) {
+	report, err := message.Payload[message.SnapshotReportInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	w.addSnapshotReport(report)
+}
+
+func (w *Worker) proccesSnapshotChannel(msgStruct message.Message) {
+	channelInfo, err := message.Payload[message.SnapshotChannelInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	w.addSnapshotChannel(channelInfo)
+}
@@ -0,0 +1,22 @@
+package worker
+
+import (
+	"distributed/job"
+	"distributed/structures"
+	"reflect"
+	"testing"
+)
+
+// the points a reorganizer collected are a part of its state until a node
+// works on them again
+func TestSnapshotCountsReorganizedPoints(t *testing.T) {
+	w := newCausalWorker(t)
+
+	w.allJobs["triangle"] = &job.Job{Name: "triangle", Working: true, Points: make([]structures.Point, 3)}
+	w.allJobs["square"] = &job.Job{Name: "square", Points: make([]structures.Point, 2)}
+
+	want := map[string]map[string]int{"triangle": {REORGANIZED: 3}}
+	if got := w.localPointCounts(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("counted %v, want %v", got, want)
+	}
+}
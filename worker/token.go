@@ -0,0 +1,452 @@
+package worker
+
+import (
+	"context"
+	"distributed/message"
+	"distributed/node"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TOKEN_RETRY_INTERVAL is how long a node waits for the token before it
+// broadcasts its request again, requests can be lost with a dead node.
+const TOKEN_RETRY_INTERVAL = 10 * time.Second
+const TOKEN_QUERY_TIMEOUT = 3 * time.Second
+
+// TOKEN_LOST_QUERIES is how many holder queries in a row must go unanswered
+// before the token counts as lost, a token passed meanwhile answers a later
+// one.
+const TOKEN_LOST_QUERIES = 2
+
+type tokenState struct {
+	TokenMutex sync.Mutex
+
+	// highest request number seen from every node, keyed by full address
+	requestNumbers map[string]int
+
+	// the token while this node holds it, nil otherwise
+	heldToken *message.TokenInfo
+	// highest token epoch seen
+	tokenEpoch        int64
+	waitingForToken   bool
+	inCriticalSection bool
+	// held from acquireToken to releaseToken, reorganizations of this node
+	// wait for each other on it
+	tokenTurn          chan struct{}
+	TokenArrivedChan   chan int32
+	tokenHolderWaiters []chan message.TokenHolderInfo
+}
+
+func (w *Worker) initToken() {
+	w.TokenMutex.Lock()
+	defer w.TokenMutex.Unlock()
+
+	w.requestNumbers = make(map[string]int)
+	w.heldToken = nil
+	w.tokenEpoch = 0
+	w.waitingForToken = false
+	w.inCriticalSection = false
+	w.TokenArrivedChan = make(chan int32, 1)
+	w.tokenTurn = make(chan struct{}, 1)
+	w.tokenHolderWaiters = make([]chan message.TokenHolderInfo, 0)
+}
+
+// createToken is called by the first node in the system and when a lost
+// token is regenerated. Pending requests count as served, their nodes will
+// request again.
+func (w *Worker) createToken() {
+	w.TokenMutex.Lock()
+	defer w.TokenMutex.Unlock()
+
+	lastServed := make(map[string]int)
+	for address, sequence := range w.requestNumbers {
+		lastServed[address] = sequence
+	}
+	w.tokenEpoch++
+	w.heldToken = &message.TokenInfo{LastServed: lastServed, Queue: make([]node.NodeInfo, 0), Epoch: w.tokenEpoch}
+
+	w.LogFileChan <- fmt.Sprintf("Created the reorganization token of epoch %d", w.tokenEpoch)
+
+	w.handToWaiting()
+}
+
+// handToWaiting lets the reorganization waiting for the token go on, false
+// means nothing waits. The caller holds TokenMutex.
+func (w *Worker) handToWaiting() bool {
+	if !w.waitingForToken {
+		return false
+	}
+	w.waitingForToken = false
+	w.inCriticalSection = true
+	w.TokenArrivedChan <- 1
+	return true
+}
+
+// acquireToken blocks until this node holds the token or ctx is done, every
+// system reorganization runs between acquireToken and releaseToken.
+func (w *Worker) acquireToken(ctx context.Context) error {
+	select {
+	case w.tokenTurn <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	w.TokenMutex.Lock()
+	if w.heldToken != nil {
+		w.inCriticalSection = true
+		w.TokenMutex.Unlock()
+		return nil
+	}
+	w.waitingForToken = true
+	w.requestToken()
+	w.TokenMutex.Unlock()
+
+	w.LogFileChan <- "Waiting for the reorganization token"
+
+	for {
+		select {
+		case <-w.TokenArrivedChan:
+			w.LogFileChan <- "Got the reorganization token"
+			return nil
+		case <-ctx.Done():
+			w.TokenMutex.Lock()
+			w.waitingForToken = false
+			w.TokenMutex.Unlock()
+
+			// a token that arrived meanwhile is passed on
+			select {
+			case <-w.TokenArrivedChan:
+				w.releaseToken()
+			default:
+				<-w.tokenTurn
+			}
+			return ctx.Err()
+		case <-time.After(TOKEN_RETRY_INTERVAL):
+			w.TokenMutex.Lock()
+			if w.waitingForToken {
+				w.LogFileChan <- "Token not recived, requesting it again"
+				w.requestToken()
+			}
+			w.TokenMutex.Unlock()
+		}
+	}
+}
+
+// requestToken broadcasts a new request, the caller holds TokenMutex.
+func (w *Worker) requestToken() {
+	address := w.WorkerNode.GetFullAddress()
+	w.requestNumbers[address]++
+
+	toSend := message.MakeTokenRequestMessage(*w.nodeInfo(), w.requestNumbers[address], w.tokenEpoch)
+	go w.broadcastMessage(w.table(), toSend)
+}
+
+func (w *Worker) releaseToken() {
+	defer func() { <-w.tokenTurn }()
+
+	w.TokenMutex.Lock()
+	w.inCriticalSection = false
+	if w.heldToken == nil {
+		w.TokenMutex.Unlock()
+		return
+	}
+
+	w.heldToken.LastServed[w.WorkerNode.GetFullAddress()] = w.requestNumbers[w.WorkerNode.GetFullAddress()]
+	w.queuePendingRequests()
+	token := w.tokenToPass()
+	w.TokenMutex.Unlock()
+
+	w.passToken(token)
+}
+
+// queuePendingRequests appends every node with an unserved request to the
+// token queue in id order, the caller holds TokenMutex.
+func (w *Worker) queuePendingRequests() {
+	queued := make(map[string]bool)
+	for _, val := range w.heldToken.Queue {
+		queued[val.GetFullAddress()] = true
+	}
+
+	systemInfo := w.table().SystemInfo
+	ids := make([]int, 0, len(systemInfo))
+	for id := range systemInfo {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		val := systemInfo[id]
+		address := val.GetFullAddress()
+		if address == w.WorkerNode.GetFullAddress() || queued[address] {
+			continue
+		}
+		if w.requestNumbers[address] > w.heldToken.LastServed[address] {
+			w.heldToken.Queue = append(w.heldToken.Queue, val)
+			queued[address] = true
+		}
+	}
+}
+
+// tokenToPass takes the token off this node if somebody is queued for it,
+// the caller holds TokenMutex and passes it after unlocking.
+func (w *Worker) tokenToPass() *message.TokenInfo {
+	if w.heldToken == nil || len(w.heldToken.Queue) == 0 {
+		return nil
+	}
+	token := w.heldToken
+	w.heldToken = nil
+	return token
+}
+
+// passToken sends the token to the first reachable node in the queue. It is
+// called without TokenMutex, the token handlers don't wait for the sends.
+func (w *Worker) passToken(token *message.TokenInfo) {
+	if token == nil {
+		return
+	}
+
+	for len(token.Queue) > 0 {
+		next := token.Queue[0]
+		token.Queue = token.Queue[1:]
+
+		reciver, ok := w.findNodeByAddress(next.GetFullAddress())
+		if !ok || reciver.GetFullAddress() == w.WorkerNode.GetFullAddress() {
+			continue
+		}
+
+		toSend := message.MakeTokenMessage(*w.nodeInfo(), reciver, *token)
+		if w.sendMessage(w.nodeInfo(), &reciver, toSend) {
+			w.LogFileChan <- "Passed the reorganization token to " + reciver.String()
+			return
+		}
+	}
+	w.keepToken(token)
+}
+
+// keepToken takes back a token nobody in its queue could take, the nodes
+// that requested it meanwhile request it again.
+func (w *Worker) keepToken(token *message.TokenInfo) {
+	w.TokenMutex.Lock()
+	defer w.TokenMutex.Unlock()
+
+	if w.heldToken != nil || token.Epoch < w.tokenEpoch {
+		// a newer token arrived meanwhile
+		return
+	}
+	w.heldToken = token
+	w.handToWaiting()
+}
+
+// passTokenOnLeave hands the token to a waiting node, or the ring successor
+// if nobody waits, so it does not leave the system with us.
+func (w *Worker) passTokenOnLeave() {
+	w.TokenMutex.Lock()
+	if w.heldToken == nil {
+		w.TokenMutex.Unlock()
+		return
+	}
+
+	w.queuePendingRequests()
+	table := w.table()
+	if len(w.heldToken.Queue) == 0 && table.Next != table.Id {
+		if next, ok := table.SystemInfo[table.Next]; ok {
+			w.heldToken.Queue = append(w.heldToken.Queue, next)
+		}
+	}
+	token := w.tokenToPass()
+	w.TokenMutex.Unlock()
+
+	w.passToken(token)
+}
+
+func (w *Worker) findNodeByAddress(address string) (node.NodeInfo, bool) {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
+
+	for _, val := range w.WorkerNode.SystemInfo {
+		if val.GetFullAddress() == address {
+			return val, true
+		}
+	}
+	return node.NodeInfo{}, false
+}
+
+// recoverToken runs after a node is removed. The node with id 0 asks who
+// holds the token and creates one of a new epoch if nobody answers
+// TOKEN_LOST_QUERIES queries. A token that was not lost after all is dropped
+// by the first node that saw the new one.
+func (w *Worker) recoverToken(removed node.NodeInfo) {
+	if w.nodeInfo().Id != 0 {
+		return
+	}
+
+	for query := 0; query < TOKEN_LOST_QUERIES; query++ {
+		if _, ok := w.queryTokenHolder(); ok {
+			return
+		}
+	}
+
+	w.LogErrorChan <- "Reorganization token lost with " + removed.String() + ", creating a new one"
+	w.createToken()
+}
+
+// queryTokenHolder broadcasts a holder query and waits for the holder to
+// answer.
+func (w *Worker) queryTokenHolder() (message.TokenHolderInfo, bool) {
+	w.TokenMutex.Lock()
+	if w.heldToken != nil {
+		holderInfo := message.TokenHolderInfo{Holder: *w.nodeInfo(), InUse: w.inCriticalSection, Queue: w.heldToken.Queue}
+		w.TokenMutex.Unlock()
+		return holderInfo, true
+	}
+	holderChan := make(chan message.TokenHolderInfo, 1)
+	w.tokenHolderWaiters = append(w.tokenHolderWaiters, holderChan)
+	w.TokenMutex.Unlock()
+
+	toSend := message.MakeTokenHolderQueryMessage(*w.nodeInfo())
+	w.broadcastMessage(w.table(), toSend)
+
+	select {
+	case holderInfo := <-holderChan:
+		return holderInfo, true
+	case <-time.After(TOKEN_QUERY_TIMEOUT):
+		w.TokenMutex.Lock()
+		for ind, val := range w.tokenHolderWaiters {
+			if val == holderChan {
+				w.tokenHolderWaiters = append(w.tokenHolderWaiters[:ind], w.tokenHolderWaiters[ind+1:]...)
+				break
+			}
+		}
+		w.TokenMutex.Unlock()
+		return message.TokenHolderInfo{}, false
+	}
+}
+
+func (w *Worker) parseTokenHolder() {
+	holderInfo, ok := w.queryTokenHolder()
+	if !ok {
+		fmt.Println("Nobody answered, the token is on its way or lost")
+		return
+	}
+
+	state := "idle"
+	if holderInfo.InUse {
+		state = "in use"
+	}
+	fmt.Printf("Token is held by %s (%s)\n", holderInfo.Holder.String(), state)
+	for ind, val := range holderInfo.Queue {
+		fmt.Printf("\t%d] %s\n", ind, val.String())
+	}
+}
+
+func (w *Worker) proccesTokenRequest(msgStruct message.Message) {
+	requestInfo, err := message.Payload[message.TokenRequestInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	address := requestInfo.Node.GetFullAddress()
+	if address == w.WorkerNode.GetFullAddress() {
+		return
+	}
+
+	w.TokenMutex.Lock()
+	if requestInfo.Sequence > w.requestNumbers[address] {
+		w.requestNumbers[address] = requestInfo.Sequence
+	}
+	if requestInfo.Epoch > w.tokenEpoch {
+		w.tokenEpoch = requestInfo.Epoch
+		if w.heldToken != nil && w.heldToken.Epoch < w.tokenEpoch {
+			// a newer token was made while this one was thought lost
+			w.LogErrorChan <- fmt.Sprintf("Dropping the stale reorganization token of epoch %d", w.heldToken.Epoch)
+			w.heldToken = nil
+			w.TokenMutex.Unlock()
+			return
+		}
+	}
+
+	var token *message.TokenInfo
+	if w.heldToken != nil && !w.inCriticalSection && w.requestNumbers[address] > w.heldToken.LastServed[address] {
+		w.queuePendingRequests()
+		token = w.tokenToPass()
+	}
+	w.TokenMutex.Unlock()
+
+	w.passToken(token)
+}
+
+func (w *Worker) proccesToken(msgStruct message.Message) {
+	tokenInfo, err := message.Payload[message.TokenInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+	if tokenInfo.LastServed == nil {
+		tokenInfo.LastServed = make(map[string]int)
+	}
+
+	w.TokenMutex.Lock()
+	if tokenInfo.Epoch < w.tokenEpoch {
+		w.LogErrorChan <- fmt.Sprintf("Dropping the stale reorganization token of epoch %d from %s", tokenInfo.Epoch, msgStruct.OriginalSender.String())
+		w.TokenMutex.Unlock()
+		return
+	}
+	if w.heldToken != nil {
+		if tokenInfo.Epoch == w.heldToken.Epoch {
+			w.LogErrorChan <- "Recived a second reorganization token from " + msgStruct.OriginalSender.String()
+			w.TokenMutex.Unlock()
+			return
+		}
+		// ours was the stale one
+		w.LogErrorChan <- fmt.Sprintf("Dropping the reorganization token of epoch %d for the one of epoch %d", w.heldToken.Epoch, tokenInfo.Epoch)
+	}
+	w.tokenEpoch = tokenInfo.Epoch
+	w.heldToken = &tokenInfo
+
+	if w.handToWaiting() {
+		w.TokenMutex.Unlock()
+		return
+	}
+
+	// the token came back after we stopped waiting, pass it on
+	w.queuePendingRequests()
+	token := w.tokenToPass()
+	w.TokenMutex.Unlock()
+
+	w.passToken(token)
+}
+
+func (w *Worker) proccesTokenHolderQuery(msgStruct message.Message) {
+	w.TokenMutex.Lock()
+	defer w.TokenMutex.Unlock()
+
+	if w.heldToken == nil {
+		return
+	}
+
+	reciver, ok := w.findNodeByAddress(msgStruct.OriginalSender.GetFullAddress())
+	if !ok {
+		reciver = msgStruct.OriginalSender
+	}
+	toSend := message.MakeTokenHolderMessage(*w.nodeInfo(), reciver, w.inCriticalSection, w.heldToken.Queue)
+	go w.sendMessage(w.nodeInfo(), &reciver, toSend)
+}
+
+func (w *Worker) proccesTokenHolder(msgStruct message.Message) {
+	holderInfo, err := message.Payload[message.TokenHolderInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	w.TokenMutex.Lock()
+	defer w.TokenMutex.Unlock()
+
+	for _, holderChan := range w.tokenHolderWaiters {
+		holderChan <- holderInfo
+	}
+	w.tokenHolderWaiters = make([]chan message.TokenHolderInfo, 0)
+}
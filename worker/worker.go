@@ -2,186 +2,315 @@ package worker
 
 import (
 	"bufio"
+	"context"
 	chanfile "distributed/chainfile"
 	"distributed/job"
 	"distributed/message"
 	"distributed/modulemath"
 	"distributed/node"
 	"distributed/structures"
+	"distributed/trace"
+	"distributed/transport"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
-	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/mitchellh/mapstructure"
 )
 
-func check(e error, addition string) {
+func (w *Worker) check(e error, addition string) {
 	if e != nil {
 		// fmt.Println(e)
-		LogErrorChan <- e.Error() + addition
+		w.LogErrorChan <- e.Error() + addition
 	}
 }
 
-func partOfSlice(route []int, nodeId int) bool {
-	for _, v := range route {
-		if v == nodeId {
-			return true
-		}
-	}
+const IMAGE_PATH = "files/images"
 
-	return false
-}
+// Worker is one node of the system. It owns all of its state, so several
+// workers can run in one process.
+type Worker struct {
+	LogFileChan  chan string
+	LogErrorChan chan string
 
-const IMAGE_PATH = "files/images"
+	ListenPortListenChan    chan int32
+	CommandPortListenChan   chan int32
+	JobProccesingPoisonChan chan int32
 
-var LogFileChan chan string
-var LogErrorChan chan string
+	BootstrapNode node.Bootstrap
 
-var ListenPortListenChan chan int32
-var CommandPortListenChan chan int32
-var JobProccesingPoisonChan chan int32
+	EnterenceChannel     chan int
+	WorkerEnteredChannel chan int
+	// whether the bootstrap took the id the worker was welcomed with
+	JoinResponseChan chan bool
+	hasEntered       bool
 
-var BootstrapNode node.Bootstrap
+	WorkerTableMutex     sync.Mutex
+	WorkerEnterenceMutex sync.Mutex
+	ConnectionWaitGroup  sync.WaitGroup
 
-var EnterenceChannel chan int
-var WorkerEnteredChannel chan int
+	// JobMutex guards allJobs, workingJob and the points running jobs add
+	JobMutex sync.Mutex
 
-var WorkerTableMutex sync.Mutex
-var WorkerEnterenceMutex sync.Mutex
-var ConnectionWaitGroup sync.WaitGroup
+	allJobs map[string]*job.Job
 
-var allJobs map[string]*job.Job
+	workingJob *job.Job
 
-var workingJob *job.Job
+	waitingChildrenArray []node.NodeInfo
+	childrenWaiting      int
 
-var waitingChildrenArray []node.NodeInfo
-var childrenWaiting int
+	clusterMap map[string]node.NodeInfo
 
-var clusterMap map[string]node.NodeInfo
+	WorkerNode node.Worker
 
-var WorkerNode node.Worker
+	// Lamport time of the worker, with vector time when it was made with
+	// vectors on
+	Clock *message.Clock
 
-var ModMath modulemath.ModMath
+	ModMath modulemath.ModMath
 
-var ImageInfoWaitingGroup sync.WaitGroup
-var ImageInfoChannel chan map[string]any
+	ClusterGate chan int32
 
-var JobStatusWaitingGroup sync.WaitGroup
-var JobStatusChannel chan job.JobStatus
+	logPath   string
+	errorPath string
+	imagePath string
+	logFile   *os.File
+	errorFile *os.File
 
-var ClusterGate chan int32
+	// canceled when the node stops, background loops end with it
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	stopped  chan struct{}
 
-func RunWorker(ipAddres string, port int, bootstrapIpAddres string, bootstrapPort int, jobs []job.Job, FILE_SEPARATOR string, listenToCli bool) {
+	broadcastState
+	causalState
+	deliveryState
+	heartbeatState
+	leaveState
+	peersState
+	recoveryState
+	replayState
+	replicationState
+	rpcState
+	snapshotState
+	tokenState
+}
+
+// JOIN_RETRY_TIMEOUT is longer than the bootstrap entry lease, so a hail
+// that got lost on a dead contact can be retried.
+const JOIN_RETRY_TIMEOUT = 15 * time.Second
+
+const DEFAULT_QUERY_TIMEOUT = 10 * time.Second
+
+// REORGANIZE_RETRIES is how many times a reorganization asks the nodes that
+// did not send their points again.
+const REORGANIZE_RETRIES = 2
+
+// Config is what RunWorker starts a worker with, the flags of the command
+// line.
+type Config struct {
+	IpAddress          string
+	Port               int
+	BootstrapIpAddress string
+	BootstrapPort      int
+	Jobs               []job.Job
+	FileSeparator      string
+	ListenToCli        bool
+	QueryTimeout       time.Duration
+	// TCP when nil
+	Transport transport.Transport
+	// records nothing when nil
+	Recorder      *trace.Recorder
+	VectorClocks  bool
+	TreeBroadcast bool
+}
+
+// RunWorker starts a worker and serves its command line until it quits.
+func RunWorker(config Config) {
 
 	fmt.Println("STARTING NEW NODE")
 	fmt.Println("--------------------------------\n\n ")
 
-	LogFileChan = make(chan string, 15)
-	LogErrorChan = make(chan string, 15)
+	w, err := NewWorker(config.IpAddress, config.Port, config.BootstrapIpAddress, config.BootstrapPort, config.Jobs, config.FileSeparator)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	w.QueryTimeout = config.QueryTimeout
+	w.Transport = config.Transport
+	w.Recorder = config.Recorder
+	w.Clock = message.NewClock(w.WorkerNode.GetFullAddress(), config.VectorClocks)
+	w.TreeBroadcast = config.TreeBroadcast
 
-	BootstrapNode = node.Bootstrap{IpAddress: bootstrapIpAddres, Port: bootstrapPort, Workers: make([]node.NodeInfo, 1)}
+	if err := w.Start(context.Background()); err != nil {
+		fmt.Println(err)
+		return
+	}
 
-	WorkerNode = node.Worker{}
-	WorkerNode.IpAddress = ipAddres
-	WorkerNode.Port = port
+	if config.ListenToCli {
+		w.listenCommand(w.CommandPortListenChan)
+	} else {
+		select {
+		case <-w.CommandPortListenChan:
+		case <-w.stopped:
+		}
+	}
 
-	WorkerNode.Connections = make(map[string]node.NodeInfo)
+	fmt.Println("JOH")
+}
+
+func NewWorker(ipAddres string, port int, bootstrapIpAddres string, bootstrapPort int, jobs []job.Job, FILE_SEPARATOR string) (*Worker, error) {
+	w := &Worker{}
+
+	w.LogFileChan = make(chan string, 15)
+	w.LogErrorChan = make(chan string, 15)
+
+	w.BootstrapNode = node.Bootstrap{IpAddress: bootstrapIpAddres, Port: bootstrapPort, Workers: make([]node.NodeInfo, 1)}
 
-	WorkerNode.SystemInfo = make(map[int]node.NodeInfo)
+	w.WorkerNode = node.Worker{}
+	w.WorkerNode.IpAddress = ipAddres
+	w.WorkerNode.Port = port
+
+	w.WorkerNode.Connections = make(map[string]node.NodeInfo)
+	w.WorkerNode.Incarnation = time.Now().UnixNano()
+
+	w.WorkerNode.SystemInfo = make(map[int]node.NodeInfo)
 	fmt.Printf("\nWut: %v\n", jobs)
 
-	allJobs = make(map[string]*job.Job)
-	workingJob = nil
-	childrenWaiting = 0
-	waitingChildrenArray = make([]node.NodeInfo, 0)
-	clusterMap = make(map[string]node.NodeInfo)
+	w.allJobs = make(map[string]*job.Job)
+	w.workingJob = nil
+	w.childrenWaiting = 0
+	w.waitingChildrenArray = make([]node.NodeInfo, 0)
+	w.clusterMap = make(map[string]node.NodeInfo)
 
 	for _, v := range jobs {
 		fmt.Printf("Job: %v\n", v)
 
-		if _, ok := allJobs[v.Name]; ok {
-			LogErrorChan <- fmt.Sprintf("Job already exist: %v", v)
+		if _, ok := w.allJobs[v.Name]; ok {
 			fmt.Printf("Job already exist: %v\n", v)
 			continue
 		}
 		vv := v
-		allJobs[vv.Name] = &vv
-	}
-
-	fmt.Printf("\nWut2: %v\n", allJobs)
-
-	LogFile, err := os.Create(fmt.Sprintf("files%soutput%sworker(%s_%d).log", FILE_SEPARATOR, FILE_SEPARATOR, ipAddres, port))
-	if err != nil {
-		fmt.Printf(err.Error(), "LogFile")
-		return
-	}
-
-	ErrorFile, err := os.Create(fmt.Sprintf("files%serror%sworker(%s_%d).log", FILE_SEPARATOR, FILE_SEPARATOR, ipAddres, port))
-	if err != nil {
-		fmt.Printf(err.Error(), "LogFile")
-		return
+		w.allJobs[vv.Name] = &vv
 	}
 
-	fmt.Println("FILES CREATED")
+	fmt.Printf("\nWut2: %v\n", w.allJobs)
 
-	ImageInfoChannel = make(chan map[string]any, 100)
-	JobStatusChannel = make(chan job.JobStatus, 100)
+	// opened by setUp, replay writes its own logs
+	w.logPath = fmt.Sprintf("files%soutput%sworker(%s_%d).log", FILE_SEPARATOR, FILE_SEPARATOR, ipAddres, port)
+	w.errorPath = fmt.Sprintf("files%serror%sworker(%s_%d).log", FILE_SEPARATOR, FILE_SEPARATOR, ipAddres, port)
+	w.imagePath = IMAGE_PATH
 
-	EnterenceChannel = make(chan int, 1)
-	WorkerEnteredChannel = make(chan int, 1)
-	EnterenceChannel <- 1
+	w.EnterenceChannel = make(chan int, 1)
+	w.WorkerEnteredChannel = make(chan int, 1)
+	w.JoinResponseChan = make(chan bool, 1)
+	w.EnterenceChannel <- 1
 
-	ListenPortListenChan = make(chan int32)
-	CommandPortListenChan = make(chan int32)
-	JobProccesingPoisonChan = make(chan int32)
-	ClusterGate = make(chan int32)
+	// buffered, quit, purge and kick must not block once the node stopped
+	w.ListenPortListenChan = make(chan int32, 1)
+	w.CommandPortListenChan = make(chan int32, 1)
+	w.JobProccesingPoisonChan = make(chan int32)
+	w.ClusterGate = make(chan int32)
 
-	WritenFile := chanfile.ChanFile{File: LogFile, InputChan: LogFileChan}
-	ErrorWritenFile := chanfile.ChanFile{File: ErrorFile, InputChan: LogErrorChan}
+	w.QueryTimeout = DEFAULT_QUERY_TIMEOUT
+	w.Clock = message.NewClock(w.WorkerNode.GetFullAddress(), false)
+	w.stopped = make(chan struct{})
 
-	LogFileChan <- fmt.Sprintf("%v", jobs)
+	return w, nil
+}
 
-	go ErrorWritenFile.WriteFileFromChan()
-	go WritenFile.WriteFileFromChan()
+// SetDir puts the files of the worker under dir instead of the working
+// directory.
+func (w *Worker) SetDir(dir string) {
+	w.logPath = filepath.Join(dir, w.logPath)
+	w.errorPath = filepath.Join(dir, w.errorPath)
+	w.imagePath = filepath.Join(dir, w.imagePath)
+}
 
-	go listenOnPort(ListenPortListenChan)
+// Start opens the listener and joins the system, it returns once the
+// bootstrap welcomed the worker. Canceling ctx stops the worker without
+// leaving the system, like a crash.
+func (w *Worker) Start(ctx context.Context) error {
+	if err := w.setUp(ctx); err != nil {
+		return err
+	}
 
-	enterneceSystemMessage := message.MakeHailMessage(WorkerNode, BootstrapNode)
-	sendMessage(WorkerNode.GetNodeInfo(), BootstrapNode.GetNodeInfo(), enterneceSystemMessage)
+	server, err := w.Transport.Listen(w.WorkerNode.GetFullAddress(), w.handleFrame, w.closedOnError)
+	if err != nil {
+		w.cancel()
+		close(w.stopped)
+		return err
+	}
+	go w.listenOnPort(server, w.ListenPortListenChan)
+
+	for joined := false; !joined; {
+		enterneceSystemMessage := message.MakeHailMessage(*w.table(), w.BootstrapNode)
+		w.sendMessage(w.nodeInfo(), w.BootstrapNode.GetNodeInfo(), enterneceSystemMessage)
+
+		for entered := false; !entered; {
+			select {
+			case <-w.WorkerEnteredChannel: // we wait to enter to system
+				entered = true
+			case <-w.ctx.Done():
+				<-w.stopped
+				return w.ctx.Err()
+			case <-time.After(JOIN_RETRY_TIMEOUT):
+				w.LogFileChan <- "Not welcomed yet, hailing the bootstrap again"
+				enterneceSystemMessage := message.MakeHailMessage(*w.table(), w.BootstrapNode)
+				w.sendMessage(w.nodeInfo(), w.BootstrapNode.GetNodeInfo(), enterneceSystemMessage)
+			}
+		}
 
-	<-WorkerEnteredChannel // we wait to enter to system
+		w.LogFileChan <- "Worker is working"
+		fmt.Println(w.table().SystemInfo)
 
-	LogFileChan <- "Worker is working"
-	fmt.Println(WorkerNode.SystemInfo)
+		if len(w.table().SystemInfo) > 1 {
+			w.makeInitConnections()
+		}
 
-	if len(WorkerNode.SystemInfo) > 1 {
-		makeInitConnections()
+		var err error
+		if joined, err = w.confirmJoin(); err != nil {
+			<-w.stopped
+			return err
+		}
+		if !joined {
+			w.LogErrorChan <- "The bootstrap gave our id to another node, hailing it again"
+			w.resetEntrance()
+		}
 	}
 
-	LogFileChan <- WorkerNode.String()
+	w.LogFileChan <- w.table().String()
 
-	toSend := message.MakeEnteredMessage(*WorkerNode.GetNodeInfo())
-	go broadcastMessage(&WorkerNode, toSend)
+	w.startHeartbeat()
+	w.startReplication()
+	w.announceEntered()
 
-	toSendBootstrap := message.MakeJoinMessage(*WorkerNode.GetNodeInfo(), *BootstrapNode.GetNodeInfo())
-	go sendMessage(WorkerNode.GetNodeInfo(), BootstrapNode.GetNodeInfo(), toSendBootstrap)
+	return nil
+}
 
-	if len(WorkerNode.SystemInfo[0].JobName) > 0 {
-		contact := WorkerNode.SystemInfo[0]
-		LogFileChan <- fmt.Sprintf("Newest in system asking %v for job", contact.String())
+// announceEntered lets the system and the bootstrap know the worker is in
+// and asks for a job to work on if the system is working.
+func (w *Worker) announceEntered() {
+	// numbered before anything else this node broadcasts
+	toSend := message.MakeEnteredMessage(*w.nodeInfo())
+	w.causalBroadcast(toSend)
+
+	table := w.table()
+	if len(table.SystemInfo[0].JobName) > 0 {
+		contact := table.SystemInfo[0]
+		w.LogFileChan <- fmt.Sprintf("Newest in system asking %v for job", contact.String())
 
 		workingJobMap := make(map[string]node.NodeInfo)
 		workingJobWorkingNode := make(map[string]int)
-		for _, nn := range WorkerNode.SystemInfo {
+		for _, nn := range table.SystemInfo {
 			if len(nn.JobName) == 0 {
-				LogErrorChan <- "JOb not wokring in working system" + nn.String()
+				w.LogErrorChan <- "JOb not wokring in working system" + nn.String()
 				continue
 			}
 			workingJobMap[nn.JobName] = nn
@@ -195,555 +324,797 @@ func RunWorker(ipAddres string, port int, bootstrapIpAddres string, bootstrapPor
 				minJob = key
 				minJobNum = val
 			}
-			if job, ok := allJobs[key]; ok {
+			w.JobMutex.Lock()
+			if job, ok := w.allJobs[key]; ok {
 				job.Working = true
-				allJobs[key] = job
+				w.allJobs[key] = job
 			}
+			w.JobMutex.Unlock()
 		}
 
 		if len(minJob) == 0 {
-			LogErrorChan <- "Null JOb"
+			w.LogErrorChan <- "Null JOb"
 		}
 
 		contact = workingJobMap[minJob]
-		toSend := message.MakeClusterKnockMessage(*WorkerNode.GetNodeInfo(), contact)
-		sendMessage(WorkerNode.GetNodeInfo(), &contact, toSend)
-	}
-
-	if listenToCli {
-		listenCommand(CommandPortListenChan)
-	} else {
-		<-CommandPortListenChan
+		toSend := message.MakeClusterKnockMessage(*w.nodeInfo(), contact)
+		w.sendMessage(w.nodeInfo(), &contact, toSend)
 	}
-
-	fmt.Println("JOH")
 }
 
-func listenOnPort(listenChan chan int32) {
-	laddr, err := net.ResolveTCPAddr("tcp", WorkerNode.GetFullAddress())
+// setUp starts the log writers and the state every handler needs, it is
+// shared by Start and Replay.
+func (w *Worker) setUp(ctx context.Context) error {
+	var err error
+	w.logFile, err = os.Create(w.logPath)
 	if err != nil {
-		fmt.Println(err)
-		check(err, "ResolveTCPAddr")
-		return
+		return err
 	}
-	ln, err := net.ListenTCP("tcp", laddr)
+	w.errorFile, err = os.Create(w.errorPath)
 	if err != nil {
-		fmt.Println(err)
-		check(err, "ResolveTCPAddr")
-		return
+		w.logFile.Close()
+		return err
 	}
-	ln.SetDeadline(time.Now().Add(10 * time.Second))
 
-	for {
+	w.ctx, w.cancel = context.WithCancel(ctx)
+
+	WritenFile := chanfile.ChanFile{File: w.logFile, InputChan: w.LogFileChan, Clock: w.Clock}
+	ErrorWritenFile := chanfile.ChanFile{File: w.errorFile, InputChan: w.LogErrorChan, Clock: w.Clock}
+
+	go ErrorWritenFile.WriteFileFromChan()
+	go WritenFile.WriteFileFromChan()
+
+	w.LogFileChan <- fmt.Sprintf("%v", w.allJobs)
+
+	if w.Transport == nil {
+		w.Transport = transport.NewTCPTransport()
+	}
+	w.initToken()
+	w.initSnapshot()
+	w.initDelivery()
+	w.initRpc()
+	w.initCausal()
+	return nil
+}
+
+// Stop leaves the system and closes the worker, it returns once the
+// listener and every connection are closed.
+func (w *Worker) Stop() {
+	w.stopOnce.Do(func() {
+		// a canceled worker is already gone, there is nothing to hand off
+		w.WorkerEnterenceMutex.Lock()
+		entered := w.hasEntered
+		w.WorkerEnterenceMutex.Unlock()
+		if entered && w.ctx.Err() == nil {
+			w.leaveSystem()
+		}
+		w.cancel()
+		<-w.stopped
+	})
+}
+
+func (w *Worker) listenOnPort(server *transport.Server, listenChan chan int32) {
+	// quit and purge stop the node right away, open connections included
+	go func() {
 		select {
 		case val := <-listenChan:
 			fmt.Println(val)
-			ln.Close()
-			return
-		default:
-			inMsg, err := ln.Accept()
-			if err != nil {
-				if err, ok := err.(net.Error); ok {
-					check(err, "(net.Error)")
-
-					ln.SetDeadline(time.Now().Add(10 * time.Second))
-				}
-			} else {
-				var msgStruct message.Message
-				json.NewDecoder(inMsg).Decode(&msgStruct)
-				processRecivedMessage(msgStruct)
-
-				inMsg.Close()
-			}
+		case <-w.ctx.Done():
 		}
-		time.Sleep(time.Millisecond * 100)
+		w.cancel()
+		server.Close()
+		w.Transport.Close()
+		w.Recorder.Close()
+		close(w.stopped)
+	}()
+
+	if err := server.Serve(); err != nil {
+		w.check(err, "Serve")
 	}
 }
 
-func processRecivedMessage(msgStruct message.Message) {
-	if msgStruct.GetReciver().Id == WorkerNode.GetId() {
-		if msgStruct.MessageType != message.StoppedJobInfo && msgStruct.MessageType != message.ImageInfo {
-			LogFileChan <- "Finally Recived " + msgStruct.Log()
+func (w *Worker) processRecivedMessage(msgStruct message.Message) {
+	w.Clock.Witness(msgStruct)
+	w.check(w.Recorder.Record(trace.In, "", msgStruct), "record")
+
+	if msgStruct.GetReciver().Id == w.nodeInfo().Id {
+		if msgStruct.MessageType == message.Ping || msgStruct.MessageType == message.Pong {
+			// heartbeats would flood the log
+		} else if msgStruct.MessageType != message.StoppedJobInfo && msgStruct.MessageType != message.ImageInfo && msgStruct.MessageType != message.HandoffPoints && msgStruct.MessageType != message.ReplicaPoints {
+			w.LogFileChan <- "Finally Recived " + msgStruct.Log()
 		} else {
-			LogFileChan <- "Finally Recived " + msgStruct.String()
+			w.LogFileChan <- "Finally Recived " + msgStruct.String()
 		}
 
 		switch msgStruct.MessageType {
 		case message.Contact:
-			go proccesContactMessage(msgStruct)
+			w.handle(w.proccesContactMessage, msgStruct)
 		case message.Welcome:
-			go proccesWelcomeMessage(msgStruct)
+			w.handle(w.proccesWelcomeMessage, msgStruct)
+		case message.JoinResponse:
+			w.handle(w.proccesJoinResponse, msgStruct)
 		case message.Entered:
-			go proccesEnteredMessage(msgStruct)
+			w.handle(w.proccesEnteredMessage, msgStruct)
 		case message.SystemKnock:
-			go proccesSystemKnockMessage(msgStruct)
+			w.handle(w.proccesSystemKnockMessage, msgStruct)
 		case message.ConnectionRequest:
-			go proccesConnectionRequest(msgStruct)
+			w.handle(w.proccesConnectionRequest, msgStruct)
 		case message.ConnectionResponse:
-			go proccesConnectionResponse(msgStruct)
+			w.handle(w.proccesConnectionResponse, msgStruct)
 		case message.ClusterKnock:
-			go proccesClusterKnock(msgStruct)
+			w.handle(w.proccesClusterKnock, msgStruct)
 		case message.ClusterWelcome:
-			go proccesClusterWelcome(msgStruct)
+			w.handle(w.proccesClusterWelcome, msgStruct)
 		case message.EnteredCluster:
-			go proccesEnteredCluster(msgStruct)
+			w.handle(w.proccesEnteredCluster, msgStruct)
 		case message.ClusterConnectionRequest:
-			go proccesClusterConnectionRequest(msgStruct)
+			w.handle(w.proccesClusterConnectionRequest, msgStruct)
 		case message.ClusterConnectionResponse:
-			go proccesClusterConnectionResponse(msgStruct)
+			w.handle(w.proccesClusterConnectionResponse, msgStruct)
 		case message.ImageInfoRequest:
-			go proccesImageInfoRequest(msgStruct)
+			w.handle(w.proccesImageInfoRequest, msgStruct)
 		case message.ImageInfo:
-			go proccesImageInfoResponse(msgStruct)
+			w.handle(w.proccesImageInfoResponse, msgStruct)
 		case message.StartJob:
-			go proccesStartJob(msgStruct)
+			w.handle(w.proccesStartJob, msgStruct)
 		case message.StartJobGenesis:
-			go proccesStartJobGenesis(msgStruct)
+			w.handle(w.proccesStartJobGenesis, msgStruct)
 		case message.ApproachCluster:
-			go proccesApproachCluster(msgStruct)
+			w.handle(w.proccesApproachCluster, msgStruct)
 		case message.JobStatus:
-			go proccesJobStatus(msgStruct)
+			w.handle(w.proccesJobStatus, msgStruct)
 		case message.JobStatusRequest:
-			go proccesJobStatusRequest(msgStruct)
+			w.handle(w.proccesJobStatusRequest, msgStruct)
 		case message.StopShareJob:
-			go proccesStopShareJob(msgStruct)
+			w.handle(w.proccesStopShareJob, msgStruct)
 		case message.StoppedJobInfo:
-			go proccesStoppedJobInfo(msgStruct)
+			w.handle(w.proccesStoppedJobInfo, msgStruct)
 		case message.UpdatedNode:
-			go proccessUpdatedNode(msgStruct)
+			w.handle(w.proccessUpdatedNode, msgStruct)
+		case message.Ping:
+			w.handle(w.proccesPing, msgStruct)
+		case message.Pong:
+			w.handle(w.proccesPong, msgStruct)
+		case message.CheckSuspect:
+			w.handle(w.proccesCheckSuspect, msgStruct)
+		case message.SuspectStatus:
+			w.handle(w.proccesSuspectStatus, msgStruct)
+		case message.HandoffPoints:
+			w.handle(w.proccesHandoffPoints, msgStruct)
+		case message.ReplicaPoints:
+			w.handle(w.proccesReplicaPoints, msgStruct)
+		case message.Kick:
+			w.handle(w.proccesKick, msgStruct)
+		case message.SystemStatusRequest:
+			w.handle(w.proccesSystemStatusRequest, msgStruct)
+		case message.Token:
+			w.handle(w.proccesToken, msgStruct)
+		case message.TokenHolder:
+			w.handle(w.proccesTokenHolder, msgStruct)
+		case message.SnapshotReport:
+			w.handle(w.proccesSnapshotReport, msgStruct)
+		case message.SnapshotChannel:
+			w.handle(w.proccesSnapshotChannel, msgStruct)
 
 		}
 	} else {
-		if partOfSlice(msgStruct.Route, WorkerNode.Id) {
-			// if msgStruct.MessageType != message.UpdatedNode {
-			LogFileChan <- fmt.Sprintf("Recived Again not Rebroadcasting %s  ~~~ ROUTE: %v I'm %d", msgStruct.Log(), msgStruct.Route, WorkerNode.Id)
-			// }
+		if isCausal(msgStruct.MessageType) && msgStruct.Causal != nil {
+			// flooded as soon as it arrives, applied once the broadcasts
+			// before it were
+			if w.holdBroadcast(msgStruct) {
+				w.forwardBroadcast(msgStruct)
+			}
+			return
+		}
+		if msgStruct.MessageType == message.NodeDead || msgStruct.MessageType == message.Quit {
+			// removal is idempotent and renumbers ids, so the route can't be
+			// trusted, only rebroadcast the first time we apply it
+			applied := false
+			if msgStruct.MessageType == message.NodeDead {
+				applied = w.proccesNodeDead(msgStruct)
+			} else {
+				applied = w.proccesQuitMessage(msgStruct)
+			}
+			if applied {
+				w.forwardBroadcast(msgStruct)
+			}
 			return
 		}
 		broadcastnext := false
 		switch msgStruct.MessageType {
 		case message.Entered:
-			go proccesEnteredMessage(msgStruct)
+			w.handle(w.proccesEnteredMessage, msgStruct)
 			broadcastnext = true
 		case message.Purge:
-			go proccesPurgeResponse(msgStruct)
-			broadcastnext = true
-		case message.Quit:
-			go proccesEnteredMessage(msgStruct)
+			w.handle(w.proccesPurgeResponse, msgStruct)
 			broadcastnext = true
 		case message.UpdatedNode:
-			go proccessUpdatedNode(msgStruct)
+			w.handle(w.proccessUpdatedNode, msgStruct)
+			broadcastnext = true
+		case message.TokenRequest:
+			w.handle(w.proccesTokenRequest, msgStruct)
+			broadcastnext = true
+		case message.TokenHolderQuery:
+			w.handle(w.proccesTokenHolderQuery, msgStruct)
+			broadcastnext = true
+		case message.SnapshotMarker:
+			w.handle(w.proccesSnapshotMarker, msgStruct)
 			broadcastnext = true
 		}
 		if broadcastnext {
-			newMsg := msgStruct.MakeMeASender(&WorkerNode)
-			LogFileChan <- fmt.Sprintf("Recived but ain't for me: %s \\ Broadcasting", msgStruct.Log())
-			broadcastMessage(&WorkerNode, newMsg)
+			w.forwardBroadcast(msgStruct)
 		} else if msgStruct.GetReciver().Id >= 0 {
-			newMsg := msgStruct.MakeMeASender(&WorkerNode)
-			nextNode := findNextNode(newMsg.GetReciver(), newMsg.GetRoute())
-			LogFileChan <- fmt.Sprintf("Recived but ain't for me: %s \\ Sanding to %d", msgStruct.Log(), nextNode.Id)
-			sendMessage(WorkerNode.GetNodeInfo(), &nextNode, newMsg)
+			newMsg := msgStruct.MakeMeASender(w.table())
+			nextNode := w.findNextNode(newMsg.GetReciver(), newMsg.GetRoute())
+			w.LogFileChan <- fmt.Sprintf("Recived but ain't for me: %s \\ Sanding to %d", msgStruct.Log(), nextNode.Id)
+			w.sendMessage(w.nodeInfo(), &nextNode, newMsg)
 		}
 
 	}
 }
 
-func proccesContactMessage(msgStruct message.Message) {
+func (w *Worker) proccesContactMessage(msgStruct message.Message) {
 
-	var ContactInfo node.NodeInfo
+	ContactInfo, err := message.Payload[node.NodeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	mapstructure.Decode(msgStruct.Message, &ContactInfo)
+	w.WorkerEnterenceMutex.Lock()
+	defer w.WorkerEnterenceMutex.Unlock()
+
+	if w.hasEntered {
+		w.LogErrorChan <- "Contact recived but already in the system " + msgStruct.Log()
+		return
+	}
 
 	if ContactInfo.Id == -1 {
-		WorkerNode.Id = 0
-		toSend := message.MakeJoinMessage(*WorkerNode.GetNodeInfo(), *BootstrapNode.GetNodeInfo())
-		LogFileChan <- "Entered system with id 0. I'm the first one"
+		w.hasEntered = true
+		w.WorkerTableMutex.Lock()
+		w.WorkerNode.Id = 0
+		w.WorkerNode.SystemInfo[0] = *w.WorkerNode.GetNodeInfo()
+		w.WorkerTableMutex.Unlock()
+
+		w.LogFileChan <- "Entered system with id 0. I'm the first one"
 
-		go sendMessage(WorkerNode.GetNodeInfo(), BootstrapNode.GetNodeInfo(), toSend)
-		WorkerNode.SystemInfo[0] = *WorkerNode.GetNodeInfo()
-		WorkerEnteredChannel <- 1
+		w.createToken()
+		w.WorkerEnteredChannel <- 1
 	} else {
-		knockMessage := message.MakeSystemKnockMessage(*WorkerNode.GetNodeInfo(), ContactInfo)
-		sendMessage(WorkerNode.GetNodeInfo(), &ContactInfo, knockMessage)
+		knockMessage := message.MakeSystemKnockMessage(*w.nodeInfo(), ContactInfo)
+		w.sendMessage(w.nodeInfo(), &ContactInfo, knockMessage)
 	}
 }
 
-func proccesWelcomeMessage(msgStruct message.Message) {
-
-	msgMap := make(map[string]interface{})
+func (w *Worker) proccesWelcomeMessage(msgStruct message.Message) {
 
-	// json.Unmarshal([]byte(msgStruct.GetMessage()), &msgMap)
-
-	// msgMap = msgStruct.Message.(map[string]interface{})
+	welcomeInfo, err := message.Payload[message.WelcomeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	mapstructure.Decode(msgStruct.Message, &msgMap)
+	w.WorkerEnterenceMutex.Lock()
+	defer w.WorkerEnterenceMutex.Unlock()
 
-	newNodeId, ok := msgMap["id"].(float64)
-	if !ok {
-		LogErrorChan <- fmt.Sprintf("¦1¦Wrong message recived: %s", msgStruct.GetMessage())
+	if w.hasEntered {
+		w.LogErrorChan <- "Welcome recived but already in the system " + msgStruct.Log()
 		return
 	}
-	WorkerNode.Id = int(newNodeId)
-	SystemInfoRecived := msgMap["systemInfo"].(map[string]interface{})
 
-	if !ok {
-		LogErrorChan <- fmt.Sprintf("¦2¦Wrong message recived: %s", msgStruct.GetMessage())
-		return
+	w.WorkerTableMutex.Lock()
+	w.WorkerNode.Id = welcomeInfo.Id
+
+	for k, tmpNI := range welcomeInfo.SystemInfo {
+		fmt.Printf("LOLOL: %v %v\n", k, tmpNI.String())
+		w.WorkerNode.SystemInfo[k] = tmpNI
 	}
+	w.WorkerNode.SystemInfo[w.WorkerNode.Id] = *w.WorkerNode.GetNodeInfo()
+
+	w.LogFileChan <- fmt.Sprintf("Finnaly entered system with id %d ", w.WorkerNode.Id)
+
+	w.LogFileChan <- fmt.Sprintf("System info: %v", w.WorkerNode.SystemInfo)
+	w.WorkerTableMutex.Unlock()
+
+	w.setCausalVector(welcomeInfo.Causal)
+
+	w.hasEntered = true
+	w.WorkerEnteredChannel <- 1
+}
+
+// confirmJoin tells the bootstrap the id the worker was welcomed with and
+// waits for it to take it, nothing is broadcast before. False means the id
+// was given to another node meanwhile.
+func (w *Worker) confirmJoin() (bool, error) {
+	toSend := message.MakeJoinMessage(*w.nodeInfo(), *w.BootstrapNode.GetNodeInfo())
+	w.sendMessage(w.nodeInfo(), w.BootstrapNode.GetNodeInfo(), toSend)
 
-	for kstr, v := range SystemInfoRecived {
-		k, _ := strconv.Atoi(kstr)
-		var tmpNI node.NodeInfo
-		mapstructure.Decode(v, &tmpNI)
-		fmt.Printf("LOLOL: %v %v\n", v, tmpNI.String())
-		WorkerNode.SystemInfo[k] = tmpNI
+	for {
+		select {
+		case accepted := <-w.JoinResponseChan:
+			return accepted, nil
+		case <-w.ctx.Done():
+			return false, w.ctx.Err()
+		case <-time.After(JOIN_RETRY_TIMEOUT):
+			w.LogFileChan <- "The bootstrap did not answer the join, sending it again"
+			toSend := message.MakeJoinMessage(*w.nodeInfo(), *w.BootstrapNode.GetNodeInfo())
+			w.sendMessage(w.nodeInfo(), w.BootstrapNode.GetNodeInfo(), toSend)
+		}
 	}
-	WorkerNode.SystemInfo[WorkerNode.Id] = *WorkerNode.GetNodeInfo()
+}
 
-	LogFileChan <- fmt.Sprintf("Finnaly entered system with id %d ", WorkerNode.Id)
+func (w *Worker) proccesJoinResponse(msgStruct message.Message) {
+	accepted, err := message.Payload[bool](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	LogFileChan <- fmt.Sprintf("System info: %v", WorkerNode.SystemInfo)
+	// a repeated join is answered again, one answer is enough
+	select {
+	case w.JoinResponseChan <- accepted:
+	default:
+	}
+}
 
-	WorkerEnteredChannel <- 1
+// resetEntrance forgets the id and the system the worker was welcomed to,
+// so it can hail the bootstrap again. The neighbours it connected to keep
+// the id for the node that took it.
+func (w *Worker) resetEntrance() {
+	w.WorkerEnterenceMutex.Lock()
+	defer w.WorkerEnterenceMutex.Unlock()
+
+	w.WorkerTableMutex.Lock()
+	w.WorkerNode.Id = 0
+	w.WorkerNode.Next = 0
+	w.WorkerNode.Prev = 0
+	w.WorkerNode.SystemInfo = make(map[int]node.NodeInfo)
+	w.WorkerTableMutex.Unlock()
+
+	// a token made as the first node is not the system's
+	w.initToken()
+	w.hasEntered = false
 }
 
-func updateNode() {
+func (w *Worker) updateNode() {
+
+	w.LogFileChan <- "Updating mee: " + w.table().String()
 
-	LogFileChan <- "Updating mee: " + WorkerNode.String()
+	toBroadCast := message.MakeUpdatedNodeMessage(*w.nodeInfo(), *w.nodeInfo())
+	w.causalBroadcast(toBroadCast)
 
-	toBroadCast := message.MakeUpdatedNodeMessage(*WorkerNode.GetNodeInfo(), *WorkerNode.GetNodeInfo())
-	broadcastMessage(&WorkerNode, toBroadCast)
+	// the bootstrap keeps the last known job of every worker for its nodes command
+	toSendBootstrap := message.MakeUpdatedNodeMessage(*w.nodeInfo(), *w.nodeInfo())
+	w.sendMessage(w.nodeInfo(), w.BootstrapNode.GetNodeInfo(), toSendBootstrap)
 }
 
-func proccessUpdatedNode(msgStruct message.Message) {
+func (w *Worker) proccessUpdatedNode(msgStruct message.Message) {
 
-	var tmpNode node.NodeInfo
-	// json.Unmarshal([]byte(msgStruct.Message), &tmpNode)
+	tmpNode, err := message.Payload[node.NodeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	mapstructure.Decode(msgStruct.Message, &tmpNode)
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
 
-	if _, ok := WorkerNode.SystemInfo[tmpNode.Id]; !ok {
-		LogErrorChan <- "Updating non existing node" + tmpNode.String()
+	if _, ok := w.WorkerNode.SystemInfo[tmpNode.Id]; !ok {
+		w.LogErrorChan <- "Updating non existing node" + tmpNode.String()
 	}
 
-	LogFileChan <- "Updating:  " + tmpNode.String()
+	w.LogFileChan <- "Updating:  " + tmpNode.String()
 
-	WorkerNode.SystemInfo[tmpNode.Id] = tmpNode
-	if strings.EqualFold(WorkerNode.JobName, tmpNode.JobName) {
-		for key, val := range clusterMap {
+	w.WorkerNode.SystemInfo[tmpNode.Id] = tmpNode
+	if len(tmpNode.JobName) > 0 && strings.EqualFold(w.WorkerNode.JobName, tmpNode.JobName) {
+		for key, val := range w.clusterMap {
 			if val.Id == tmpNode.Id {
-				delete(clusterMap, key)
-				clusterMap[tmpNode.FractalId] = tmpNode
+				delete(w.clusterMap, key)
 				break
 			}
 		}
+		// nodes that took over an orphaned fractal id join the cluster this way
+		w.clusterMap[tmpNode.FractalId] = tmpNode
 	}
 }
 
-func proccesSystemKnockMessage(msgStruct message.Message) {
+func (w *Worker) proccesSystemKnockMessage(msgStruct message.Message) {
 
-	WorkerEnterenceMutex.Lock()
-	defer WorkerEnterenceMutex.Unlock()
+	w.WorkerEnterenceMutex.Lock()
+	defer w.WorkerEnterenceMutex.Unlock()
 
-	LogFileChan <- fmt.Sprintf("Node: %v knocked on this system. I'm contact.", msgStruct.OriginalSender)
+	w.LogFileChan <- fmt.Sprintf("Node: %v knocked on this system. I'm contact.", msgStruct.OriginalSender)
 
-	maxIndex := WorkerNode.Id
-	for _, val := range WorkerNode.SystemInfo {
+	table := w.table()
+	maxIndex := table.Id
+	for _, val := range table.SystemInfo {
 		if maxIndex < val.Id {
 			maxIndex = val.Id
 		}
 	}
-	if maxIndex != WorkerNode.Id {
-		LogFileChan <- fmt.Sprintf("Node: %v knocked on this system,But Im not youngest in the system (Node %d)", msgStruct.OriginalSender, maxIndex)
-		tmp := WorkerNode.SystemInfo[maxIndex]
-		newMessage := msgStruct.MakeMeASender(&WorkerNode)
-		sendMessage(&msgStruct.OriginalSender, &tmp, newMessage)
+	if maxIndex != table.Id {
+		w.LogFileChan <- fmt.Sprintf("Node: %v knocked on this system,But Im not youngest in the system (Node %d)", msgStruct.OriginalSender, maxIndex)
+		tmp := table.SystemInfo[maxIndex]
+		newMessage := msgStruct.MakeMeASender(w.table())
+		w.sendMessage(&msgStruct.OriginalSender, &tmp, newMessage)
 		return
 	}
 
 	reciver := msgStruct.GetSender()
 	nextIndex := maxIndex + 1
 
-	toSand := message.MakeWelcomeMessage(*WorkerNode.GetNodeInfo(), reciver, nextIndex, WorkerNode.SystemInfo)
-	sendMessage(WorkerNode.GetNodeInfo(), &reciver, toSand)
+	toSand := message.MakeWelcomeMessage(*table.GetNodeInfo(), reciver, nextIndex, table.SystemInfo, w.causalVector())
+	w.sendMessage(w.nodeInfo(), &reciver, toSand)
 
 }
 
-func proccesEnteredMessage(msgStruct message.Message) {
+func (w *Worker) proccesEnteredMessage(msgStruct message.Message) {
 
-	var newNodeInfo node.NodeInfo
+	newNodeInfo, err := message.Payload[node.NodeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	mapstructure.Decode(msgStruct.Message, &newNodeInfo)
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
 
-	if val, ok := WorkerNode.SystemInfo[newNodeInfo.Id]; ok {
-		LogErrorChan <- fmt.Sprintf("Tried to info system %v , but already have %v", newNodeInfo, val)
-		return
+	for _, val := range w.WorkerNode.SystemInfo {
+		if val.GetFullAddress() == newNodeInfo.GetFullAddress() {
+			w.LogErrorChan <- fmt.Sprintf("Tried to info system %v , but already have %v", newNodeInfo, val)
+			return
+		}
 	}
 
-	WorkerNode.SystemInfo[newNodeInfo.Id] = newNodeInfo
-	LogFileChan <- fmt.Sprintf("New node in the system: %v", newNodeInfo)
+	// the new node is the youngest. Its id was given before the removals
+	// concurrent with its entry, some nodes apply them first and compact
+	// the ids before it
+	if newNodeInfo.Id != len(w.WorkerNode.SystemInfo) {
+		w.LogFileChan <- fmt.Sprintf("Entered %v after the removals it did not see, taking id %d", newNodeInfo, len(w.WorkerNode.SystemInfo))
+		newNodeInfo.Id = len(w.WorkerNode.SystemInfo)
+	}
+	w.WorkerNode.SystemInfo[newNodeInfo.Id] = newNodeInfo
+	w.LogFileChan <- fmt.Sprintf("New node in the system: %v", newNodeInfo)
 }
 
-func proccesConnectionRequest(msgStruct message.Message) {
+func (w *Worker) proccesConnectionRequest(msgStruct message.Message) {
 
-	direction := msgStruct.GetMessage().(string)
+	smer, err := message.Payload[message.ConnectionSmer](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+	direction := string(smer)
 
+	w.WorkerTableMutex.Lock()
 	if strings.Compare(direction, string(message.Next)) == 0 {
-		WorkerNode.Prev = msgStruct.OriginalSender.Id
+		w.WorkerNode.Prev = msgStruct.OriginalSender.Id
 	} else {
-		WorkerNode.Next = msgStruct.OriginalSender.Id
+		w.WorkerNode.Next = msgStruct.OriginalSender.Id
 	}
+	w.WorkerTableMutex.Unlock()
 
-	toSend := message.MakeConnectionResponseMessage(*WorkerNode.GetNodeInfo(), msgStruct.GetSender(), true, message.ConnectionSmer(direction))
-	sendMessage(WorkerNode.GetNodeInfo(), &msgStruct.OriginalSender, toSend)
+	toSend := message.MakeConnectionResponseMessage(*w.nodeInfo(), msgStruct.GetSender(), true, message.ConnectionSmer(direction))
+	w.sendMessage(w.nodeInfo(), &msgStruct.OriginalSender, toSend)
 }
 
-func proccesConnectionResponse(msgStruct message.Message) {
-
-	tmpMap := make(map[string]interface{})
-
-	// tmpMap = msgStruct.Message.(map[string]interface{})
+func (w *Worker) proccesConnectionResponse(msgStruct message.Message) {
 
-	mapstructure.Decode(msgStruct.GetMessage(), &tmpMap)
-
-	accepted, _ := tmpMap["accepted"].(bool)
-	direction := tmpMap["smer"].(string)
+	response, err := message.Payload[message.ConnectionResponseInfo](msgStruct)
+	if err != nil {
+		// taken as refused, the node waiting for it goes on
+		w.LogErrorChan <- err.Error()
+		w.ConnectionWaitGroup.Done()
+		return
+	}
+	direction := string(response.Smer)
 
-	if accepted {
+	if response.Accepted {
+		w.WorkerTableMutex.Lock()
 		if strings.Compare(direction, string(message.Next)) == 0 {
-			WorkerNode.Next = msgStruct.OriginalSender.Id
+			w.WorkerNode.Next = msgStruct.OriginalSender.Id
 		} else {
-			WorkerNode.Prev = msgStruct.OriginalSender.Id
+			w.WorkerNode.Prev = msgStruct.OriginalSender.Id
 		}
+		w.WorkerTableMutex.Unlock()
 	}
-	ConnectionWaitGroup.Done()
+	w.ConnectionWaitGroup.Done()
+}
+
+func (w *Worker) proccesPurgeResponse(msgStruct message.Message) {
+
+	w.ListenPortListenChan <- 1
+	w.CommandPortListenChan <- 1
+	w.LogFileChan <- "System purge"
 }
 
-func proccesPurgeResponse(msgStruct message.Message) {
+func (w *Worker) proccesKick(msgStruct message.Message) {
 
-	CommandPortListenChan <- 1
-	ListenPortListenChan <- 1
-	LogFileChan <- "System purge"
+	w.LogFileChan <- "Kicked out of the system by the bootstrap"
+	fmt.Println("Kicked out of the system by the bootstrap")
+
+	w.leaveSystem()
+	w.ListenPortListenChan <- 1
+	w.CommandPortListenChan <- 1
 }
 
-func proccesJobStatus(msgStruct message.Message) {
+func (w *Worker) proccesSystemStatusRequest(msgStruct message.Message) {
 
-	newJobStatus := new(job.JobStatus)
+	jobStatusMap, _ := w.collectJobStatus(w.ctx, "")
 
-	// newJobStatus = msgStruct.Message.(job.JobStatus)
+	toSend := message.MakeSystemStatusMessage(*w.nodeInfo(), msgStruct.GetSender(), jobStatusMap).AnswerTo(msgStruct)
+	w.sendMessage(w.nodeInfo(), &msgStruct.OriginalSender, toSend)
+}
 
-	tmpMap := make(map[string]interface{})
+func (w *Worker) proccesJobStatus(msgStruct message.Message) {
 
-	mapstructure.Decode(msgStruct.Message, &newJobStatus)
-	mapstructure.Decode(msgStruct.Message, &tmpMap)
+	newJobStatus, err := message.Payload[job.JobStatus](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	LogFileChan <- newJobStatus.Log()
-	LogFileChan <- fmt.Sprintf("%v %v", tmpMap, tmpMap["pointNodes"])
+	w.LogFileChan <- newJobStatus.Log()
 
-	JobStatusChannel <- *newJobStatus
-	JobStatusWaitingGroup.Done()
+	w.deliverReply(msgStruct)
 }
 
-func proccesJobStatusRequest(msgStruct message.Message) {
+func (w *Worker) proccesJobStatusRequest(msgStruct message.Message) {
+
+	self := w.nodeInfo()
 
 	var jobStatus job.JobStatus
-	jobStatus.Name = WorkerNode.JobName
-	jobStatus.PointsPerNodes = map[string]int{WorkerNode.FractalId: -1}
+	jobStatus.Name = self.JobName
+	jobStatus.PointsPerNodes = map[string]int{self.FractalId: -1}
 
+	workingJob := w.runningJob()
 	if workingJob == nil {
-		LogErrorChan <- "Asked for Job status but there is no job"
+		w.LogErrorChan <- "Asked for Job status but there is no job"
 	} else {
-		jobStatus = *workingJob.GetJobStatus(WorkerNode.FractalId)
-		LogFileChan <- "Asked for Job status: " + jobStatus.Log() + fmt.Sprintf(" PP: %p", workingJob)
+		jobStatus = *workingJob.GetJobStatus(self.FractalId)
+		w.adoptedJobStatus(&jobStatus)
+		w.LogFileChan <- "Asked for Job status: " + jobStatus.Log() + fmt.Sprintf(" PP: %p", workingJob)
 	}
 
-	toSend := message.MakeJobStatusMessage(*WorkerNode.GetNodeInfo(), msgStruct.GetSender(), jobStatus)
-	nextNode := findNextNode(msgStruct.GetSender(), toSend.Route)
+	toSend := message.MakeJobStatusMessage(*w.nodeInfo(), msgStruct.GetSender(), jobStatus).AnswerTo(msgStruct)
+	nextNode := w.findNextNode(msgStruct.GetSender(), toSend.Route)
 
-	sendMessage(WorkerNode.GetNodeInfo(), &nextNode, toSend)
+	w.sendMessage(w.nodeInfo(), &nextNode, toSend)
 }
 
-func proccesClusterKnock(msgStruct message.Message) {
+func (w *Worker) proccesClusterKnock(msgStruct message.Message) {
 
-	<-ClusterGate
+	<-w.ClusterGate
 
-	lastFractalID := WorkerNode.FractalId
+	table := w.table()
+	lastFractalID := table.FractalId
 	clusterInfo := make(map[int]node.NodeInfo)
-	for ind, val := range WorkerNode.SystemInfo {
-		if val.JobName == WorkerNode.JobName {
-			if ModMath.CompareTwoNumbs(lastFractalID, val.FractalId) < 0 {
+	for ind, val := range table.SystemInfo {
+		if val.JobName == table.JobName {
+			if w.ModMath.CompareTwoNumbs(lastFractalID, val.FractalId) < 0 {
 				lastFractalID = val.FractalId
 			}
 			clusterInfo[ind] = val
 		}
 	}
-	fmt.Printf("%v\n\t%s FID\n", WorkerNode.SystemInfo, lastFractalID)
-	nextOne := ModMath.NextOne(lastFractalID)
+	fmt.Printf("%v\n\t%s FID\n", table.SystemInfo, lastFractalID)
+	nextOne := w.ModMath.NextOne(lastFractalID)
 
 	sender := msgStruct.GetSender()
 
-	LogFileChan <- fmt.Sprintf("%v", WorkerNode.SystemInfo)
-	LogFileChan <- fmt.Sprintf("Adding Node %s with FractalID %s for Job %s", (&sender).String(), nextOne, WorkerNode.JobName)
+	w.LogFileChan <- fmt.Sprintf("%v", table.SystemInfo)
+	w.LogFileChan <- fmt.Sprintf("Adding Node %s with FractalID %s for Job %s", (&sender).String(), nextOne, table.JobName)
 
-	toSend := message.MakeClusterWelcomeMessage(*WorkerNode.GetNodeInfo(), msgStruct.GetSender(), nextOne, WorkerNode.JobName)
-	sendMessage(WorkerNode.GetNodeInfo(), &sender, toSend)
-	ClusterGate <- 1
+	toSend := message.MakeClusterWelcomeMessage(*table.GetNodeInfo(), msgStruct.GetSender(), nextOne, table.JobName)
+	w.sendMessage(w.nodeInfo(), &sender, toSend)
+	w.ClusterGate <- 1
 }
 
-func proccesClusterWelcome(msgStruct message.Message) {
-
-	input := make(map[string]string)
+func (w *Worker) proccesClusterWelcome(msgStruct message.Message) {
 
-	// input = msgStruct.Message.(map[string]string)
+	input, err := message.Payload[message.ClusterWelcomeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	mapstructure.Decode(msgStruct.Message, &input)
+	fractalID := input.FractalId
+	jobName := input.JobName
 
-	fractalID := input["fractalID"]
-	jobName := input["jobName"]
+	jobInfo := w.knownJob(jobName)
+	if jobInfo == nil {
+		w.LogErrorChan <- "Welcomed to a cluster of unknown job " + jobName
+		return
+	}
+	if err := checkFractalId(jobInfo, fractalID); err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
 	fmt.Println("\n--------------------------")
 	fmt.Println(fractalID, " @@ ", jobName)
 	fmt.Println("--------------------------")
 
-	ModMath.SetN(int32(allJobs[jobName].PointCount))
+	w.ModMath.SetN(int32(jobInfo.PointCount))
+
+	ClusterInfoMap := input.ClusterInfo
 
-	var ClusterInfoMap map[int]node.NodeInfo
-	json.Unmarshal([]byte(input["ClusterInfo"]), &ClusterInfoMap)
+	w.WorkerTableMutex.Lock()
+	w.WorkerNode.FractalId = fractalID
+	w.WorkerNode.JobName = jobName
+	w.WorkerNode.SystemInfo[w.WorkerNode.Id] = *w.WorkerNode.GetNodeInfo()
 
-	WorkerNode.FractalId = fractalID
-	WorkerNode.JobName = jobName
-	WorkerNode.SystemInfo[WorkerNode.Id] = *WorkerNode.GetNodeInfo()
+	for _, val := range ClusterInfoMap {
+		w.WorkerNode.SystemInfo[val.Id] = val
+		w.clusterMap[val.FractalId] = val
+	}
+	w.WorkerTableMutex.Unlock()
 
 	for _, val := range ClusterInfoMap {
-		WorkerNode.SystemInfo[val.Id] = val
-		clusterMap[val.FractalId] = val
-		LogFileChan <- fmt.Sprintf("Cluster connection: %d", modulemath.EditDistance(fractalID, val.FractalId))
+		w.LogFileChan <- fmt.Sprintf("Cluster connection: %d", modulemath.EditDistance(fractalID, val.FractalId))
 		if modulemath.EditDistance(fractalID, val.FractalId) == 1 {
-			toSendic := message.MakeClusterConnectionRequestMessage(*WorkerNode.GetNodeInfo(), val)
-			nextNode := findNextNode(val, toSendic.Route)
-			go sendMessage(WorkerNode.GetNodeInfo(), &nextNode, toSendic)
+			toSendic := message.MakeClusterConnectionRequestMessage(*w.nodeInfo(), val)
+			nextNode := w.findNextNode(val, toSendic.Route)
+			go w.sendMessage(w.nodeInfo(), &nextNode, toSendic)
 		}
 	}
 
-	for _, val := range WorkerNode.SystemInfo {
-		toSend := message.MakeEnteredClusterMessage(*WorkerNode.GetNodeInfo(), val, *WorkerNode.GetNodeInfo())
-		nextOne := findNextNode(val, toSend.Route)
+	for _, val := range w.table().SystemInfo {
+		toSend := message.MakeEnteredClusterMessage(*w.nodeInfo(), val, *w.nodeInfo())
+		nextOne := w.findNextNode(val, toSend.Route)
 
-		sendMessage(WorkerNode.GetNodeInfo(), &nextOne, toSend)
+		w.sendMessage(w.nodeInfo(), &nextOne, toSend)
 	}
 
-	ClusterGate <- 1
+	w.ClusterGate <- 1
 }
 
-func proccesStopShareJob(msgStruct message.Message) {
-
-	var jobInput job.Job
+func (w *Worker) proccesStopShareJob(msgStruct message.Message) {
 
-	// jobInput = msgStruct.Message.(job.Job)
+	jobInput, err := message.Payload[job.Job](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	mapstructure.Decode(msgStruct.Message, &jobInput)
+	// a retry of the reorganizer is answered with the points already sent,
+	// the job is stopped only once
+	if reply, first := w.claimStopShare(msgStruct); !first {
+		if reply == nil {
+			w.LogFileChan <- fmt.Sprintf("Already stopping for request %d", msgStruct.RequestId)
+			return
+		}
+		w.LogFileChan <- fmt.Sprintf("Answering request %d again", msgStruct.RequestId)
+		nextNode := w.findNextNode(msgStruct.OriginalSender, msgStruct.Route)
+		w.sendMessage(w.nodeInfo(), &nextNode, reply)
+		return
+	}
 
-	if _, ok := allJobs[jobInput.Name]; !ok {
-		LogFileChan <- "New Job is adding: " + jobInput.Log() + " :::: "
-		allJobs[jobInput.Name] = &jobInput
+	w.JobMutex.Lock()
+	if known, ok := w.allJobs[jobInput.Name]; !ok {
+		w.LogFileChan <- "New Job is adding: " + jobInput.Log() + " :::: "
+		w.allJobs[jobInput.Name] = &jobInput
+	} else if !jobInput.Working && known.Working {
+		// the reorganizer stopped the job
+		known.Working = false
+		known.Points = make([]structures.Point, 0)
 	}
+	working := w.workingJob != nil
+	w.JobMutex.Unlock()
 
-	if workingJob == nil {
-		LogErrorChan <- "No job running to stop" + WorkerNode.String()
-		LogErrorChan <- WorkerNode.String() + " lolg"
-
-		WorkerNode.JobName = ""
-		WorkerNode.FractalId = ""
-		clusterMap = make(map[string]node.NodeInfo)
-		WorkerNode.SystemInfo[WorkerNode.Id] = *WorkerNode.GetNodeInfo()
-
-		toSend := message.MakeStoppedJobInfoMessage(*WorkerNode.GetNodeInfo(), msgStruct.GetSender(), "", []structures.Point{})
-		nextNode := findNextNode(msgStruct.OriginalSender, msgStruct.Route)
-		LogFileChan <- fmt.Sprintf("Sending StopeedINfo to %d throus %d:  %s", toSend.GetReciver().Id, nextNode.Id, toSend.Log())
-		sendMessage(WorkerNode.GetNodeInfo(), &nextNode, toSend)
-	} else {
-		JobProccesingPoisonChan <- 1
-		LogFileChan <- "Stopping and Sharing job: " + workingJob.Name
+	w.clearReplicas()
+
+	if !working {
+		w.LogErrorChan <- "No job running to stop" + w.table().String()
 
-		clusterMap = make(map[string]node.NodeInfo)
+		w.leaveCluster()
 
-		WorkerNode.JobName = ""
-		WorkerNode.FractalId = ""
+		toSend := message.MakeStoppedJobInfoMessage(*w.nodeInfo(), msgStruct.GetSender(), "", []structures.Point{}).AnswerTo(msgStruct)
+		nextNode := w.findNextNode(msgStruct.OriginalSender, msgStruct.Route)
+		w.LogFileChan <- fmt.Sprintf("Sending StopeedINfo to %d throus %d:  %s", toSend.GetReciver().Id, nextNode.Id, toSend.Log())
+		w.sendPointMessage(&nextNode, toSend)
+		w.answeredStopShare(msgStruct, toSend)
+	} else {
+		w.JobProccesingPoisonChan <- 1
+		workingJob := w.runningJob()
+		w.JobMutex.Lock()
+		w.workingJob = nil
+		w.JobMutex.Unlock()
+		w.LogFileChan <- "Stopping and Sharing job: " + workingJob.Name
 
-		WorkerNode.SystemInfo[WorkerNode.Id] = *WorkerNode.GetNodeInfo()
+		w.leaveCluster()
 
-		updateNode()
+		w.updateNode()
 
-		LogFileChan <- "Im here buty why"
+		w.LogFileChan <- "Im here buty why"
 
-		toSend := message.MakeStoppedJobInfoMessage(*WorkerNode.GetNodeInfo(), msgStruct.GetSender(), workingJob.Name, workingJob.Points)
-		nextNode := findNextNode(msgStruct.OriginalSender, msgStruct.Route)
-		sendMessage(WorkerNode.GetNodeInfo(), &nextNode, toSend)
+		points := append(workingJob.Points, w.takeInheritedPoints(workingJob.Name)...)
+		points = append(points, w.stopAdoptedJobs()...)
+		toSend := message.MakeStoppedJobInfoMessage(*w.nodeInfo(), msgStruct.GetSender(), workingJob.Name, points).AnswerTo(msgStruct)
+		nextNode := w.findNextNode(msgStruct.OriginalSender, msgStruct.Route)
+		w.sendPointMessage(&nextNode, toSend)
+		w.answeredStopShare(msgStruct, toSend)
 
-		fmt.Printf("Ending dummy len:^ %d\n", len(allJobs[workingJob.Name].Points))
+		fmt.Printf("Ending dummy len:^ %d\n", len(w.knownJob(workingJob.Name).Points))
 
 		// currJob := allJobs[workingJob.Name]
 		// currJob.Points = make([]structures.Point, 0)
 		// allJobs[currJob.Name] = currJob
-		<-ClusterGate
-
-		workingJob = nil
+		<-w.ClusterGate
 	}
 }
 
-func proccesStoppedJobInfo(msgStruct message.Message) {
+// leaveCluster forgets the job and cluster of the worker.
+func (w *Worker) leaveCluster() {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
 
-	tmpJob := make(map[string]any)
+	w.WorkerNode.JobName = ""
+	w.WorkerNode.FractalId = ""
+	w.clusterMap = make(map[string]node.NodeInfo)
+	w.WorkerNode.SystemInfo[w.WorkerNode.Id] = *w.WorkerNode.GetNodeInfo()
+}
 
-	// tmpJob = msgStruct.Message.(map[string]any)
+func (w *Worker) proccesStoppedJobInfo(msgStruct message.Message) {
 
-	mapstructure.Decode(msgStruct.Message, &tmpJob)
+	stoppedInfo, err := message.Payload[message.PointsInfo](msgStruct)
+	if err != nil {
+		// not delivered, the node is reported as missing
+		w.LogErrorChan <- err.Error()
+		return
+	}
+	w.recivePointMessage(msgStruct, stoppedInfo.JobName, "", len(stoppedInfo.Points))
 
-	tmpNode := WorkerNode.SystemInfo[msgStruct.GetSender().Id]
+	w.WorkerTableMutex.Lock()
+	tmpNode := w.WorkerNode.SystemInfo[msgStruct.GetSender().Id]
 	tmpNode.FractalId = ""
 	tmpNode.JobName = ""
 
-	WorkerNode.SystemInfo[tmpNode.Id] = tmpNode
+	w.WorkerNode.SystemInfo[tmpNode.Id] = tmpNode
+	w.WorkerTableMutex.Unlock()
 
-	ImageInfoChannel <- tmpJob
-
-	ImageInfoWaitingGroup.Done()
+	w.deliverReply(msgStruct)
 }
 
-func ReorganizeSystem(intrusiveJob *job.Job) {
-	for _, val := range WorkerNode.SystemInfo {
-		// if val.Id == WorkerNode.Id {
-		// 	continue
-		// }
-
-		toSend := message.MakeStopShareJobMessage(*WorkerNode.GetNodeInfo(), val, *intrusiveJob)
-		nextNode := findNextNode(val, toSend.Route)
-		LogFileChan <- fmt.Sprintf("<><>> Sending StopShare to %d throus %d:  %s", toSend.GetReciver().Id, nextNode.Id, toSend.Log())
+func (w *Worker) ReorganizeSystem(ctx context.Context, intrusiveJob *job.Job) {
+	if err := w.acquireToken(ctx); err != nil {
+		w.LogErrorChan <- "Not reorganizing, no token: " + err.Error()
+		return
+	}
+	defer w.releaseToken()
 
-		sendMessage(WorkerNode.GetNodeInfo(), &nextNode, toSend)
+	table := w.table()
+	asked := make([]node.NodeInfo, 0, len(table.SystemInfo))
+	for _, val := range table.SystemInfo {
+		asked = append(asked, val)
+	}
 
-		ImageInfoWaitingGroup.Add(1)
+	// a stopped node has nothing left to send, so a late answer must not be lost
+	replies, missing := w.callNodesRetrying(ctx, asked, REORGANIZE_RETRIES, func(reciver node.NodeInfo) *message.Message {
+		toSend := message.MakeStopShareJobMessage(*w.nodeInfo(), reciver, *intrusiveJob)
+		w.LogFileChan <- fmt.Sprintf("<><>> Sending StopShare to %d:  %s", toSend.GetReciver().Id, toSend.Log())
+		return toSend
+	})
+	if len(missing) > 0 {
+		w.LogErrorChan <- "Reorganizing without the points of " + describeMissing(missing)
 	}
 
 	WorkingJobsMap := make(map[string]*job.Job)
 
-	for _, jj := range allJobs {
+	w.JobMutex.Lock()
+	for _, jj := range w.allJobs {
 		if jj.Working {
 			WorkingJobsMap[jj.Name] = jj
 		}
 	}
 
-	ImageInfoWaitingGroup.Wait()
-
-	for j := 0; j < len(WorkerNode.SystemInfo); j++ {
-		tmpJob := <-ImageInfoChannel
-		jobName := tmpJob["jobName"].(string)
-		ppoints := make([]structures.Point, 0)
-		mapstructure.Decode(tmpJob["points"], &ppoints)
+	for _, reply := range replies {
+		tmpJob, _ := message.Payload[message.PointsInfo](reply)
+		jobName := tmpJob.JobName
+		ppoints := tmpJob.Points
 
 		if val, ok := WorkingJobsMap[jobName]; !ok {
-			LogErrorChan <- "Unknown working job: " + jobName
+			w.LogErrorChan <- "Unknown working job: " + jobName
 		} else {
 			val.Points = append(val.Points, ppoints...)
 			WorkingJobsMap[val.Name] = val
@@ -755,43 +1126,48 @@ func ReorganizeSystem(intrusiveJob *job.Job) {
 		workingJobs = append(workingJobs, *job)
 		fmt.Printf("WORKING: %s  %d\n", job.Name, len(job.Points))
 	}
+	w.JobMutex.Unlock()
 
 	noWorkingJobs := len(workingJobs)
 	if noWorkingJobs == 0 {
-		LogFileChan <- "No job to work"
+		w.LogFileChan <- "No job to work"
 		return
 	}
 
 	i := 0
 	for ; i < noWorkingJobs; i++ {
-		reciver := WorkerNode.SystemInfo[i]
+		reciver := table.SystemInfo[i]
 		jobic := workingJobs[i]
-		LogFileChan <- "Sending job to start: " + jobic.Log()
-		msg := message.MakeStartJobGenesisMessage(*WorkerNode.GetNodeInfo(), reciver, jobic.Name)
-		nextNode := findNextNode(reciver, msg.Route)
-		sendMessage(WorkerNode.GetNodeInfo(), &nextNode, msg)
+		w.LogFileChan <- "Sending job to start: " + jobic.Log()
+		msg := message.MakeStartJobGenesisMessage(*w.nodeInfo(), reciver, jobic.Name)
+		nextNode := w.findNextNode(reciver, msg.Route)
+		w.sendMessage(w.nodeInfo(), &nextNode, msg)
 	}
 
 	// jobInd := 0
 
-	for i = noWorkingJobs; i < len(WorkerNode.SystemInfo); i++ {
+	for i = noWorkingJobs; i < len(table.SystemInfo); i++ {
 
-		reciver := WorkerNode.SystemInfo[i]
+		reciver := table.SystemInfo[i]
 
 		// jobInd := reciver.Id % noWorkingJobs
 
 		contactId := reciver.Id - noWorkingJobs
 
-		contact := WorkerNode.SystemInfo[contactId]
+		contact := table.SystemInfo[contactId]
 
-		LogFileChan <- fmt.Sprintf("%s to %s to cLust %d", &reciver, &contact, contactId)
+		w.LogFileChan <- fmt.Sprintf("%s to %s to cLust %d", &reciver, &contact, contactId)
 
-		msg := message.MakeApproachClusterMessage(*WorkerNode.GetNodeInfo(), reciver, contact)
-		nextNode := findNextNode(reciver, msg.Route)
-		sendMessage(WorkerNode.GetNodeInfo(), &nextNode, msg)
+		msg := message.MakeApproachClusterMessage(*w.nodeInfo(), reciver, contact)
+		nextNode := w.findNextNode(reciver, msg.Route)
+		w.sendMessage(w.nodeInfo(), &nextNode, msg)
 
 		// jobInd = (jobInd + 1) % noWorkingJobs
-		time.Sleep(time.Second * 5)
+		select {
+		case <-time.After(time.Second * 5):
+		case <-ctx.Done():
+			return
+		}
 
 	}
 
@@ -819,259 +1195,378 @@ func scaleJob(jobInput *job.Job, scalePoint structures.Point, scale float64) *jo
 	return newJob
 }
 
-func splitWorkingJob() {
+// checkFractalId tells why a fractal id a peer sent is not a part of the
+// job, every digit picks one of the main points.
+func checkFractalId(jobInput *job.Job, fractalID string) error {
+	if len(fractalID) == 0 {
+		return fmt.Errorf("empty fractal id for job %s", jobInput.Name)
+	}
+	for _, ch := range fractalID {
+		ind := int(ch - '0')
+		if ch < '0' || ind >= jobInput.PointCount || ind >= len(jobInput.MainPoints) {
+			return fmt.Errorf("fractal id %s is not a part of job %s", fractalID, jobInput.Name)
+		}
+	}
+	return nil
+}
+
+// scaleJobToFractal scales the whole job down to the part of the fractal
+// that the fractal id describes.
+func scaleJobToFractal(jobInput *job.Job, fractalID string) (*job.Job, error) {
+	if jobInput == nil {
+		return nil, errors.New("unknown job")
+	}
+	if err := checkFractalId(jobInput, fractalID); err != nil {
+		return nil, err
+	}
+
+	scaledJob := new(job.Job)
 
-	JobProccesingPoisonChan <- 1
+	*scaledJob = *jobInput
 
+	scale := 1.0 / (float64(scaledJob.PointCount - 1))
+
+	for _, ch := range fractalID {
+		ind := (int(ch) - '0')
+		scaledJob = scaleJob(scaledJob, scaledJob.MainPoints[ind], scale)
+	}
+
+	return scaledJob, nil
+}
+
+func (w *Worker) splitWorkingJob(children []node.NodeInfo) {
+
+	w.JobProccesingPoisonChan <- 1
+
+	workingJob := w.runningJob()
 	scale := 1.0 / (float64(workingJob.PointCount - 1))
-	LogFileChan <- fmt.Sprintf("Spliting job %s into %d parts", workingJob.Name, workingJob.PointCount)
+	w.LogFileChan <- fmt.Sprintf("Spliting job %s into %d parts", workingJob.Name, workingJob.PointCount)
 
 	for ind := 1; ind < workingJob.PointCount; ind++ {
-		toSend := message.MakeStartJobMessage(*WorkerNode.GetNodeInfo(), waitingChildrenArray[ind-1])
+		toSend := message.MakeStartJobMessage(*w.nodeInfo(), children[ind-1])
 
-		sendMessage(WorkerNode.GetNodeInfo(), &waitingChildrenArray[ind-1], toSend)
+		w.sendMessage(w.nodeInfo(), &children[ind-1], toSend)
 	}
 
-	*workingJob = *scaleJob(workingJob, workingJob.MainPoints[0], scale)
-	waitingChildrenArray = make([]node.NodeInfo, 0)
-	LogFileChan <- "Staring partial job: " + workingJob.Log() + " }])"
+	// the rescaled job replaces the old one, replication may still be
+	// sending a copy of it
+	workingJob = scaleJob(workingJob, workingJob.MainPoints[0], scale)
+	w.JobMutex.Lock()
+	w.workingJob = workingJob
+	w.JobMutex.Unlock()
+	w.resetReplication()
+	w.LogFileChan <- "Staring partial job: " + workingJob.Log() + " }])"
 
-	go startJob(workingJob)
+	go w.startJob(workingJob, w.JobProccesingPoisonChan)
 }
 
-func proccesEnteredCluster(msgStruct message.Message) {
+func (w *Worker) proccesEnteredCluster(msgStruct message.Message) {
+
+	nodeInput, err := message.Payload[node.NodeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
+
+	if len(nodeInput.JobName) > 0 {
+		jobInfo := w.knownJob(nodeInput.JobName)
+		if jobInfo == nil {
+			w.LogErrorChan <- fmt.Sprintf("Node %s entered a cluster of unknown job %s", nodeInput.String(), nodeInput.JobName)
+			return
+		}
+		if err := checkFractalId(jobInfo, nodeInput.FractalId); err != nil {
+			w.LogErrorChan <- err.Error()
+			return
+		}
+	}
 
-	var nodeInput node.NodeInfo
+	// the children are taken under the lock, the job is split after it is
+	// released since splitting sends to them
+	var children []node.NodeInfo
+	deeper := false
 
-	mapstructure.Decode(msgStruct.Message, &nodeInput)
+	w.JobMutex.Lock()
+	pointCount := 0
+	if w.workingJob != nil {
+		pointCount = w.workingJob.PointCount
+	}
+	w.JobMutex.Unlock()
 
-	if _, ok := clusterMap[nodeInput.FractalId]; ok {
-		LogErrorChan <- fmt.Sprintf("Node with the same fractalId %s>> %s in Cluster  %v", nodeInput.FractalId, nodeInput.String(), clusterMap)
+	w.WorkerTableMutex.Lock()
+	if _, ok := w.clusterMap[nodeInput.FractalId]; ok {
+		w.LogErrorChan <- fmt.Sprintf("Node with the same fractalId %s>> %s in Cluster  %v", nodeInput.FractalId, nodeInput.String(), w.clusterMap)
 		// return
 	}
 
-	if len(WorkerNode.FractalId) > 0 && workingJob != nil && strings.EqualFold(WorkerNode.JobName, nodeInput.JobName) {
+	if len(w.WorkerNode.FractalId) > 0 && pointCount > 0 && strings.EqualFold(w.WorkerNode.JobName, nodeInput.JobName) {
 
-		clusterMap[nodeInput.FractalId] = nodeInput
+		w.clusterMap[nodeInput.FractalId] = nodeInput
 
-		if strings.Compare(nodeInput.FractalId[:len(nodeInput.FractalId)-1], WorkerNode.FractalId) == 0 {
-			LogFileChan <- fmt.Sprintf("Node %v is waiting,(1)", nodeInput.String())
-			childrenWaiting++
-			waitingChildrenArray = append(waitingChildrenArray, nodeInput)
-			if childrenWaiting == workingJob.PointCount-1 {
-				splitWorkingJob()
-				waitingChildrenArray = make([]node.NodeInfo, 0)
-				childrenWaiting = 0
-				WorkerNode.FractalId = WorkerNode.FractalId + "0"
-				WorkerNode.SystemInfo[WorkerNode.Id] = *WorkerNode.GetNodeInfo()
-
-				updateNode()
-			}
-		} else if len(nodeInput.FractalId) == 1 && len(WorkerNode.FractalId) == 1 {
-			LogFileChan <- fmt.Sprintf("Node %v is waiting,(2)", nodeInput.String())
-			childrenWaiting++
-			waitingChildrenArray = append(waitingChildrenArray, nodeInput)
-			if childrenWaiting == workingJob.PointCount-1 {
-				splitWorkingJob()
-				waitingChildrenArray = make([]node.NodeInfo, 0)
-				childrenWaiting = 0
+		waiting := false
+		if strings.Compare(nodeInput.FractalId[:len(nodeInput.FractalId)-1], w.WorkerNode.FractalId) == 0 {
+			w.LogFileChan <- fmt.Sprintf("Node %v is waiting,(1)", nodeInput.String())
+			waiting, deeper = true, true
+		} else if len(nodeInput.FractalId) == 1 && len(w.WorkerNode.FractalId) == 1 {
+			w.LogFileChan <- fmt.Sprintf("Node %v is waiting,(2)", nodeInput.String())
+			waiting = true
+		}
+		if waiting {
+			w.childrenWaiting++
+			w.waitingChildrenArray = append(w.waitingChildrenArray, nodeInput)
+			if w.childrenWaiting == pointCount-1 {
+				children = w.waitingChildrenArray
+				w.waitingChildrenArray = make([]node.NodeInfo, 0)
+				w.childrenWaiting = 0
 			}
 		}
 	}
 
-	WorkerNode.SystemInfo[nodeInput.Id] = nodeInput
+	w.WorkerNode.SystemInfo[nodeInput.Id] = nodeInput
+	w.WorkerTableMutex.Unlock()
+
+	if children != nil {
+		w.splitWorkingJob(children)
+		if deeper {
+			w.WorkerTableMutex.Lock()
+			w.WorkerNode.FractalId = w.WorkerNode.FractalId + "0"
+			w.WorkerNode.SystemInfo[w.WorkerNode.Id] = *w.WorkerNode.GetNodeInfo()
+			w.WorkerTableMutex.Unlock()
+
+			w.updateNode()
+		}
+	}
 
 	if len(nodeInput.JobName) > 0 {
-		tmpJob := allJobs[nodeInput.JobName]
+		w.JobMutex.Lock()
+		tmpJob := w.allJobs[nodeInput.JobName]
 		tmpJob.Working = true
-		allJobs[tmpJob.Name] = tmpJob
+		w.allJobs[tmpJob.Name] = tmpJob
+		w.JobMutex.Unlock()
 	}
 }
 
-func proccesClusterConnectionRequest(msgStruct message.Message) {
-	if modulemath.EditDistance(msgStruct.GetSender().FractalId, WorkerNode.FractalId) != 1 {
-		LogErrorChan <- fmt.Sprintf("Wrong Cluster Connection! Wrong Edit Distance %s", msgStruct.GetSender().FractalId)
+func (w *Worker) proccesClusterConnectionRequest(msgStruct message.Message) {
+	fractalId := w.nodeInfo().FractalId
+	if modulemath.EditDistance(msgStruct.GetSender().FractalId, fractalId) != 1 {
+		w.LogErrorChan <- fmt.Sprintf("Wrong Cluster Connection! Wrong Edit Distance %s", msgStruct.GetSender().FractalId)
 		return
 	}
 
 	sender := msgStruct.GetSender()
 
-	if modulemath.EditDistance(sender.FractalId, WorkerNode.FractalId) != 1 {
-		LogFileChan <- "Cluster connection with " + sender.String()
-		toSend := message.MakeClusterConnectionResponseMessage(*WorkerNode.GetNodeInfo(), sender, false)
-		sendMessage(WorkerNode.GetNodeInfo(), &sender, toSend)
+	if modulemath.EditDistance(sender.FractalId, fractalId) != 1 {
+		w.LogFileChan <- "Cluster connection with " + sender.String()
+		toSend := message.MakeClusterConnectionResponseMessage(*w.nodeInfo(), sender, false)
+		w.sendMessage(w.nodeInfo(), &sender, toSend)
 	}
 
-	WorkerNode.Connections[sender.FractalId] = sender
-	LogFileChan <- "Cluster connection with " + sender.String()
-	toSend := message.MakeClusterConnectionResponseMessage(*WorkerNode.GetNodeInfo(), sender, true)
-	sendMessage(WorkerNode.GetNodeInfo(), &sender, toSend)
+	w.WorkerTableMutex.Lock()
+	w.WorkerNode.Connections[sender.FractalId] = sender
+	w.WorkerTableMutex.Unlock()
+	w.LogFileChan <- "Cluster connection with " + sender.String()
+	toSend := message.MakeClusterConnectionResponseMessage(*w.nodeInfo(), sender, true)
+	w.sendMessage(w.nodeInfo(), &sender, toSend)
 }
 
-func proccesClusterConnectionResponse(msgStruct message.Message) {
+func (w *Worker) proccesClusterConnectionResponse(msgStruct message.Message) {
 
-	accept, _ := msgStruct.Message.(bool)
+	accept, err := message.Payload[bool](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 	sender := msgStruct.GetSender()
 
 	if !accept {
-		LogErrorChan <- "Refused connection in cluster from " + sender.FractalId
+		w.LogErrorChan <- "Refused connection in cluster from " + sender.FractalId
 		return
 	}
-	LogFileChan <- "Cluster connection accepted by " + sender.String()
+	w.LogFileChan <- "Cluster connection accepted by " + sender.String()
 
-	WorkerNode.Connections[sender.FractalId] = sender
+	w.WorkerTableMutex.Lock()
+	w.WorkerNode.Connections[sender.FractalId] = sender
+	w.WorkerTableMutex.Unlock()
 }
 
-func proccesStartJobGenesis(msgStruct message.Message) {
+func (w *Worker) proccesStartJobGenesis(msgStruct message.Message) {
 
-	jobName := msgStruct.Message.(string)
-
-	if _, ok := allJobs[jobName]; !ok {
-		LogErrorChan <- fmt.Sprintf("Job %s doenst exist...", jobName)
+	jobName, err := message.Payload[string](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
 		return
 	}
 
-	childrenWaiting = 0
-	waitingChildrenArray = make([]node.NodeInfo, 0)
-
-	workingJob = new(job.Job)
-
-	*workingJob = *allJobs[jobName]
-
-	WorkerNode.JobName = workingJob.Name
-	WorkerNode.FractalId = "0"
-
-	if workingJob == allJobs[jobName] {
-		check(errors.New("why is this happening"), "work")
+	workingJob := w.knownJob(jobName)
+	if workingJob == nil {
+		w.LogErrorChan <- fmt.Sprintf("Job %s doenst exist...", jobName)
+		return
 	}
 
-	WorkerNode.SystemInfo[WorkerNode.Id] = *WorkerNode.GetNodeInfo()
+	w.JobMutex.Lock()
+	w.workingJob = workingJob
+	w.JobMutex.Unlock()
 
-	updateNode()
+	w.WorkerTableMutex.Lock()
+	w.childrenWaiting = 0
+	w.waitingChildrenArray = make([]node.NodeInfo, 0)
 
-	LogFileChan <- "Starting job: " + workingJob.Log()
+	w.WorkerNode.JobName = workingJob.Name
+	w.WorkerNode.FractalId = "0"
+	w.WorkerNode.SystemInfo[w.WorkerNode.Id] = *w.WorkerNode.GetNodeInfo()
+	w.WorkerTableMutex.Unlock()
 
-	go startJob(workingJob)
-
-	ClusterGate <- 1
-}
+	w.updateNode()
 
-func proccesStartJob(msgStruct message.Message) {
+	w.LogFileChan <- "Starting job: " + workingJob.Log()
 
-	workingJob = new(job.Job)
+	go w.startJob(workingJob, w.JobProccesingPoisonChan)
 
-	*workingJob = *allJobs[WorkerNode.JobName]
+	w.ClusterGate <- 1
+}
 
-	scale := 1.0 / (float64(workingJob.PointCount - 1))
+func (w *Worker) proccesStartJob(msgStruct message.Message) {
 
-	for _, ch := range WorkerNode.FractalId {
-		ind := (int(ch) - '0')
-		workingJob = scaleJob(workingJob, workingJob.MainPoints[ind], scale)
+	self := w.nodeInfo()
+	workingJob, err := scaleJobToFractal(w.knownJob(self.JobName), self.FractalId)
+	if err != nil {
+		w.LogErrorChan <- fmt.Sprintf("Can't start %s:%s: %v", self.JobName, self.FractalId, err)
+		return
 	}
+	w.JobMutex.Lock()
+	w.workingJob = workingJob
+	w.JobMutex.Unlock()
 
-	LogFileChan <- "Starting job: " + workingJob.Log()
+	w.LogFileChan <- "Starting job: " + workingJob.Log()
 
-	go startJob(workingJob)
+	go w.startJob(workingJob, w.JobProccesingPoisonChan)
 }
 
-func proccesApproachCluster(msgStruct message.Message) {
+func (w *Worker) proccesApproachCluster(msgStruct message.Message) {
 
-	var contact node.NodeInfo
-
-	mapstructure.Decode(msgStruct.Message, &contact)
+	contact, err := message.Payload[node.NodeInfo](msgStruct)
+	if err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
-	toSend := message.MakeClusterKnockMessage(*WorkerNode.GetNodeInfo(), contact)
-	nextNode := findNextNode(contact, toSend.Route)
+	toSend := message.MakeClusterKnockMessage(*w.nodeInfo(), contact)
+	nextNode := w.findNextNode(contact, toSend.Route)
 
-	sendMessage(WorkerNode.GetNodeInfo(), &nextNode, toSend)
+	w.sendMessage(w.nodeInfo(), &nextNode, toSend)
 
 }
 
-func proccesImageInfoRequest(msgStruct message.Message) {
-
-	var toSend *message.Message
-
-	if workingJob == nil {
-		LogErrorChan <- "Asked for image info but dont having job"
-		toSend = message.MakeImageInfoMessage(*WorkerNode.GetNodeInfo(), msgStruct.OriginalSender, "", []structures.Point{})
-	} else {
-		tmpJob := *workingJob
-
-		toSend = message.MakeImageInfoMessage(*WorkerNode.GetNodeInfo(), msgStruct.OriginalSender, tmpJob.Name, tmpJob.Points)
+func (w *Worker) proccesImageInfoRequest(msgStruct message.Message) {
 
+	jobName, err := message.Payload[string](msgStruct)
+	if err != nil {
+		// answered with no points, the asking node does not wait for us
+		w.LogErrorChan <- err.Error()
+		toSend := message.MakeImageInfoMessage(*w.nodeInfo(), msgStruct.OriginalSender, "", []structures.Point{}).AnswerTo(msgStruct)
+		nextNode := w.findNextNode(msgStruct.GetSender(), msgStruct.Route)
+		w.sendMessage(w.nodeInfo(), &nextNode, toSend)
+		return
 	}
 
-	nextNode := findNextNode(msgStruct.GetSender(), msgStruct.Route)
+	points := make([]structures.Point, 0)
+	if workingJob := w.runningJob(); workingJob != nil && strings.EqualFold(workingJob.Name, jobName) {
+		points = append(points, workingJob.Points...)
+	}
+	// replicas of dead nodes and adopted fractal ids live outside the working job
+	points = append(points, w.inheritedPointsFor(jobName)...)
+	points = append(points, w.adoptedPointsFor(jobName)...)
 
-	sendMessage(WorkerNode.GetNodeInfo(), &nextNode, toSend)
+	if len(points) == 0 {
+		w.LogErrorChan <- "Asked for image info but dont having job " + jobName
+		jobName = ""
+	}
 
-}
+	toSend := message.MakeImageInfoMessage(*w.nodeInfo(), msgStruct.OriginalSender, jobName, points).AnswerTo(msgStruct)
 
-func proccesImageInfoResponse(msgStruct message.Message) {
+	nextNode := w.findNextNode(msgStruct.GetSender(), msgStruct.Route)
 
-	tmpJob := make(map[string]any)
+	w.sendMessage(w.nodeInfo(), &nextNode, toSend)
 
-	// tmpJob = msgStruct.Message.(map[string]any)
+}
 
-	mapstructure.Decode(msgStruct.Message, &tmpJob)
+func (w *Worker) proccesImageInfoResponse(msgStruct message.Message) {
 
-	ImageInfoChannel <- tmpJob
-	ImageInfoWaitingGroup.Done()
+	if _, err := message.Payload[message.PointsInfo](msgStruct); err != nil {
+		w.LogErrorChan <- err.Error()
+		return
+	}
 
+	w.deliverReply(msgStruct)
 }
 
-func makeInitConnections() {
+func (w *Worker) makeInitConnections() {
 
-	ConnectionWaitGroup.Add(2)
-	toSendNext := message.MakeConnectionRequestMessage(*WorkerNode.GetNodeInfo(), WorkerNode.SystemInfo[0], message.Next)
-	tmpNI := WorkerNode.SystemInfo[0]
-	sendMessage(WorkerNode.GetNodeInfo(), &tmpNI, toSendNext)
+	table := w.table()
+	w.ConnectionWaitGroup.Add(2)
+	toSendNext := message.MakeConnectionRequestMessage(*w.nodeInfo(), table.SystemInfo[0], message.Next)
+	tmpNI := table.SystemInfo[0]
+	w.sendMessage(w.nodeInfo(), &tmpNI, toSendNext)
 
-	prevNode := WorkerNode.SystemInfo[WorkerNode.Id-1]
+	prevNode := table.SystemInfo[table.Id-1]
 
 	fmt.Println((&prevNode).String())
-	fmt.Println(WorkerNode.String())
+	fmt.Println(table.String())
 
-	fmt.Println(WorkerNode.SystemInfo)
-	toSendPrev := message.MakeConnectionRequestMessage(*WorkerNode.GetNodeInfo(), prevNode, message.Prev)
+	fmt.Println(table.SystemInfo)
+	toSendPrev := message.MakeConnectionRequestMessage(*w.nodeInfo(), prevNode, message.Prev)
 	tmpNI = prevNode
-	sendMessage(WorkerNode.GetNodeInfo(), &tmpNI, toSendPrev)
+	w.sendMessage(w.nodeInfo(), &tmpNI, toSendPrev)
 
-	ConnectionWaitGroup.Wait()
-	LogFileChan <- "This is because"
+	w.ConnectionWaitGroup.Wait()
+	w.LogFileChan <- "This is because"
 }
 
-func sendMessage(sender, reciver *node.NodeInfo, msg message.IMessage) bool {
-	connOut, err := net.DialTimeout("tcp", reciver.GetFullAddress(), time.Duration(1)*time.Second)
-	if err != nil {
-		if _, ok := err.(net.Error); ok {
-			// fmt.Println("Error received while connecting to ", reciver.NodeId)
-			check(err, "sendMessage__"+reciver.GetFullAddress())
-			return false
-		}
-	} else {
-		json.NewEncoder(connOut).Encode(&msg)
-		connOut.Close()
+func (w *Worker) sendMessage(sender, reciver *node.NodeInfo, msg message.IMessage) bool {
+	address := reciver.GetFullAddress()
+
+	msgStruct, acked := msg.(*message.Message)
+	acked = acked && w.needsAck(address, msgStruct)
+
+	if msgStruct != nil {
+		// broadcasts send one message to several nodes at once
+		stamped := *msgStruct
+		w.Clock.Stamp(&stamped)
+		msgStruct = &stamped
+		msg = msgStruct
 	}
 
-	return true
-}
+	var data []byte
+	var err error
+	if acked {
+		data, err = w.marshalForHop(msgStruct)
+	} else {
+		data, err = json.Marshal(msg)
+	}
+	if err != nil {
+		w.check(err, "sendMessage")
+		return false
+	}
 
-func broadcastMessage(sender *node.Worker, msg message.IMessage) bool {
-	result := true
-	LogFileChan <- fmt.Sprintf("Broadcasting to: %v", sender.SystemInfo)
+	if msgStruct != nil {
+		w.check(w.Recorder.Record(trace.Out, address, *msgStruct), "record")
+	}
 
-	reciver := sender.SystemInfo[sender.Next]
-	sendMessage(sender.GetNodeInfo(), &reciver, msg)
+	// queued before sending, the ack can come back before Send returns
+	var key outboxKey
+	if acked {
+		key = outboxKey{keyOf(msgStruct), address}
+		w.addToOutbox(key, data, msgStruct.Log())
+	}
 
-	reciver = sender.SystemInfo[sender.Prev]
-	sendMessage(sender.GetNodeInfo(), &reciver, msg)
-	for _, val := range sender.Connections {
-		LogFileChan <- fmt.Sprintf("Broadcasting from %d to %d throu %d", sender.Id, val.Id, val.Id)
-		result = sendMessage(sender.GetNodeInfo(), &val, msg)
+	if err := w.Transport.Send(address, data, w.isNeighbour(address)); err != nil {
+		// fmt.Println("Error received while connecting to ", reciver.NodeId)
+		w.check(err, "sendMessage__"+address)
+		// a failure can be transient, the outbox sends it again and backs
+		// off like for a lost ack
+		return acked
 	}
 
-	return result
+	return true
 }
 
 func nextPoint(start, end structures.Point, ratio float64) structures.Point {
@@ -1081,25 +1576,59 @@ func nextPoint(start, end structures.Point, ratio float64) structures.Point {
 	return structures.Point{X: int(new_x), Y: int(new_y)}
 }
 
-func startJob(jobInput *job.Job) {
+func (w *Worker) startJob(jobInput *job.Job, poisonChan chan int32) {
 	point := jobInput.MainPoints[0]
 	ratio := jobInput.Ratio
 	for {
 		select {
-		case <-JobProccesingPoisonChan:
-			LogFileChan <- "Ending job:" + jobInput.Name
+		case <-poisonChan:
+			w.LogFileChan <- "Ending job:" + jobInput.Name
+			return
+		case <-w.ctx.Done():
 			return
 		default:
 			indPoint := rand.Intn(jobInput.PointCount)
 			point = nextPoint(point, jobInput.MainPoints[indPoint], ratio.AsFloat())
 			// LogFileChan <- fmt.Sprintf("New point: %v to Main point: %v", point, jobInput.MainPoints[indPoint])
+			w.JobMutex.Lock()
 			jobInput.Points = append(jobInput.Points, point)
+			w.JobMutex.Unlock()
 		}
 		time.Sleep(time.Millisecond * 20)
 	}
 }
 
-func AskForNewJob(name string) *job.Job {
+// knownJob returns a copy of a job the worker knows, or nil.
+func (w *Worker) knownJob(name string) *job.Job {
+	w.JobMutex.Lock()
+	defer w.JobMutex.Unlock()
+
+	jobInfo, ok := w.allJobs[name]
+	if !ok {
+		return nil
+	}
+	return copyJob(jobInfo)
+}
+
+// runningJob returns a copy of the working job, or nil. startJob keeps adding
+// points to it and a split rescales it in place.
+func (w *Worker) runningJob() *job.Job {
+	w.JobMutex.Lock()
+	defer w.JobMutex.Unlock()
+
+	if w.workingJob == nil {
+		return nil
+	}
+	return copyJob(w.workingJob)
+}
+
+func copyJob(jobInput *job.Job) *job.Job {
+	tmp := *jobInput
+	tmp.Points = append(make([]structures.Point, 0, len(jobInput.Points)), jobInput.Points...)
+	return &tmp
+}
+
+func (w *Worker) AskForNewJob(name string) *job.Job {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("Number of points	:> ")
 	text, _ := reader.ReadString('\n')
@@ -1107,7 +1636,7 @@ func AskForNewJob(name string) *job.Job {
 
 	pointCount, err := strconv.Atoi(text)
 	if err != nil {
-		check(err, "PointCount")
+		w.check(err, "PointCount")
 	}
 	fmt.Println(pointCount, " ", text)
 
@@ -1117,7 +1646,7 @@ func AskForNewJob(name string) *job.Job {
 
 	ration, err := strconv.ParseFloat(text, 32)
 	if err != nil {
-		check(err, "Ratio")
+		w.check(err, "Ratio")
 	}
 
 	fmt.Print("Height	:> ")
@@ -1126,7 +1655,7 @@ func AskForNewJob(name string) *job.Job {
 
 	height, err := strconv.Atoi(text)
 	if err != nil {
-		check(err, "Height")
+		w.check(err, "Height")
 	}
 
 	fmt.Print("Width	:> ")
@@ -1135,7 +1664,7 @@ func AskForNewJob(name string) *job.Job {
 
 	width, err := strconv.Atoi(text)
 	if err != nil {
-		check(err, "Width")
+		w.check(err, "Width")
 	}
 
 	points := make([]structures.Point, pointCount)
@@ -1164,96 +1693,109 @@ func AskForNewJob(name string) *job.Job {
 	return newJob
 }
 
-func parseStartJob(name string) {
-	LogFileChan <- "Starting job: " + name
-	job, ok := allJobs[name]
-	if !ok {
-		LogFileChan <- "There is no job: " + name + ". Creating new job"
-		job = AskForNewJob(name)
-		allJobs[name] = job
+func (w *Worker) parseStartJob(ctx context.Context, name string) {
+	w.LogFileChan <- "Starting job: " + name
+	job := w.knownJob(name)
+	if job == nil {
+		w.LogFileChan <- "There is no job: " + name + ". Creating new job"
+		job = w.AskForNewJob(name)
 	}
 	job.Working = true
-	allJobs[job.Name] = job
-	ReorganizeSystem(job)
+	w.JobMutex.Lock()
+	w.allJobs[job.Name] = copyJob(job)
+	w.JobMutex.Unlock()
+	w.ReorganizeSystem(ctx, job)
 	// go startJob(job)
 }
 
-func parseStopJob(name string) {
-	LogFileChan <- "Stopping job: " + name
-	job, ok := allJobs[name]
-	if !ok || !job.Working {
-		LogErrorChan <- "There is no job: " + name + ". Error no job to stop"
+func (w *Worker) parseStopJob(ctx context.Context, name string) {
+	w.LogFileChan <- "Stopping job: " + name
+	job := w.knownJob(name)
+	if job == nil || !job.Working {
+		w.LogErrorChan <- "There is no job: " + name + ". Error no job to stop"
 		return
 	}
 
 	job.Working = false
 	job.Points = make([]structures.Point, 0)
-	allJobs[job.Name] = job
-	ReorganizeSystem(job)
+	w.JobMutex.Lock()
+	w.allJobs[job.Name] = copyJob(job)
+	w.JobMutex.Unlock()
+	w.ReorganizeSystem(ctx, job)
 
 }
 
-func GetOneJobResult(name string) int {
-	nodeWaiting := 0
-
-	for _, node := range WorkerNode.SystemInfo {
-		if strings.EqualFold(name, node.JobName) {
-			msg := message.MakeImageInfoRequestMessage(*WorkerNode.GetNodeInfo(), node)
-			nextNode := findNextNode(node, msg.Route)
-			ImageInfoWaitingGroup.Add(1)
-			sendMessage(WorkerNode.GetNodeInfo(), &nextNode, msg)
-			nodeWaiting++
-
-		}
+func (w *Worker) GetOneJobResult(ctx context.Context, name string) ([]message.Message, []node.NodeInfo) {
+	// every node is asked, replicas of a job can be kept outside its cluster
+	systemInfo := w.table().SystemInfo
+	asked := make([]node.NodeInfo, 0, len(systemInfo))
+	for _, node := range systemInfo {
+		asked = append(asked, node)
 	}
-	LogFileChan <- fmt.Sprintf("Waiting: %d", nodeWaiting)
-	ImageInfoWaitingGroup.Wait()
+	w.LogFileChan <- fmt.Sprintf("Waiting: %d", len(asked))
 
-	return nodeWaiting
+	return w.callImageInfo(ctx, asked, name)
 }
 
-func GetOneNodeForJobResult(name, fractalID string) int {
-	for _, node := range WorkerNode.SystemInfo {
+func (w *Worker) GetOneNodeForJobResult(ctx context.Context, name, fractalID string) ([]message.Message, []node.NodeInfo) {
+	asked := make([]node.NodeInfo, 0, 1)
+	for _, node := range w.table().SystemInfo {
 		if strings.EqualFold(name, node.JobName) && strings.EqualFold(fractalID, node.FractalId) {
-			msg := message.MakeImageInfoRequestMessage(*WorkerNode.GetNodeInfo(), node)
-			nextNode := findNextNode(node, msg.Route)
-
-			ImageInfoWaitingGroup.Add(1)
-			sendMessage(WorkerNode.GetNodeInfo(), &nextNode, msg)
+			asked = append(asked, node)
 			break
 		}
 	}
 
-	ImageInfoWaitingGroup.Wait()
+	return w.callImageInfo(ctx, asked, name)
+}
+
+func (w *Worker) callImageInfo(ctx context.Context, asked []node.NodeInfo, name string) ([]message.Message, []node.NodeInfo) {
+	return w.callNodes(ctx, asked, func(reciver node.NodeInfo) *message.Message {
+		return message.MakeImageInfoRequestMessage(*w.nodeInfo(), reciver, name)
+	})
+}
+
+func (w *Worker) parseResultJob(args string) {
+	jobFinal, missing, ok := w.collectJobResult(w.ctx, args)
+	if !ok {
+		return
+	}
 
-	return 1
+	jobFinal.MakeImage(w.imagePath)
+	if len(missing) > 0 {
+		fmt.Printf("Image of %s is partial, no answer in %v from: %s\n", jobFinal.Name, w.QueryTimeout, describeMissing(missing))
+	}
 }
 
-func parseResultJob(args string) {
-	LogFileChan <- "Result getting: " + args
+// collectJobResult gathers the points of the asked job and returns the nodes
+// that did not answer in time, ok is false when the job is not working.
+func (w *Worker) collectJobResult(ctx context.Context, args string) (job.Job, []node.NodeInfo, bool) {
+	w.LogFileChan <- "Result getting: " + args
 	args_array := strings.SplitN(args, " ", 2)
 
 	name := args_array[0]
-	nodeWaiting := 0
+	var responses []message.Message
+	var missing []node.NodeInfo
 
 	switch len(args_array) {
 	case 1:
-		LogFileChan <- "One job result"
-		nodeWaiting = GetOneJobResult(args_array[0])
+		w.LogFileChan <- "One job result"
+		responses, missing = w.GetOneJobResult(ctx, args_array[0])
 	case 2:
-		LogFileChan <- "One job on one node result"
-		nodeWaiting = GetOneNodeForJobResult(args_array[0], args_array[1])
+		w.LogFileChan <- "One job on one node result"
+		responses, missing = w.GetOneNodeForJobResult(ctx, args_array[0], args_array[1])
 	default:
-		LogErrorChan <- "wrong number of arguments: " + args
+		w.LogErrorChan <- "wrong number of arguments: " + args
 	}
 
-	jobFinalTmp, ok := allJobs[name]
-	if !ok || !jobFinalTmp.Working {
-		LogErrorChan <- "There is no job: " + name
-		return
+	var jobFinal job.Job
+
+	jobFinalTmp := w.knownJob(name)
+	if jobFinalTmp == nil || !jobFinalTmp.Working {
+		w.LogErrorChan <- "There is no job: " + name
+		return jobFinal, missing, false
 	}
 
-	var jobFinal job.Job
 	jobFinal.Name = jobFinalTmp.Name
 	jobFinal.Width = jobFinalTmp.Width
 	jobFinal.Height = jobFinalTmp.Height
@@ -1262,11 +1804,10 @@ func parseResultJob(args string) {
 	jobFinal.PointCount = jobFinalTmp.PointCount
 	jobFinal.Points = make([]structures.Point, 0)
 
-	for i := 0; i < nodeWaiting; i++ {
-		tmpJobReuslt := <-ImageInfoChannel
-		jobName := tmpJobReuslt["jobName"].(string)
-		ppoints := make([]structures.Point, 0)
-		mapstructure.Decode(tmpJobReuslt["points"], &ppoints)
+	for _, response := range responses {
+		tmpJobReuslt, _ := message.Payload[message.PointsInfo](response)
+		jobName := tmpJobReuslt.JobName
+		ppoints := tmpJobReuslt.Points
 		if len(jobName) == 0 {
 			continue
 		}
@@ -1274,101 +1815,104 @@ func parseResultJob(args string) {
 		if strings.EqualFold(jobName, jobFinal.Name) {
 			jobFinal.Points = append(jobFinal.Points, ppoints...)
 		} else {
-			LogErrorChan <- "What name is this? " + jobName
+			w.LogErrorChan <- "What name is this? " + jobName
 		}
 
 	}
 
-	jobFinal.MakeImage(IMAGE_PATH)
+	return jobFinal, missing, true
 }
 
-func parseListNodes() {
-	fmt.Printf("Listing system nodes for node: %s\n", WorkerNode.String())
-	for ind, n := range WorkerNode.SystemInfo {
+func (w *Worker) parseListNodes() {
+	table := w.table()
+	fmt.Printf("Listing system nodes for node: %s\n", table.String())
+	for ind, n := range table.SystemInfo {
 		fmt.Printf("%d> %v\n", ind, n.String())
 	}
 }
 
-func allJobsStatus() int {
-	for _, node := range WorkerNode.SystemInfo {
-		msg := message.MakeJobStatusRequestMessage(*WorkerNode.GetNodeInfo(), node)
-		nextNode := findNextNode(node, msg.Route)
-
-		JobStatusWaitingGroup.Add(1)
-		sendMessage(WorkerNode.GetNodeInfo(), &nextNode, msg)
+func (w *Worker) allJobsStatus(ctx context.Context) ([]message.Message, []node.NodeInfo) {
+	systemInfo := w.table().SystemInfo
+	asked := make([]node.NodeInfo, 0, len(systemInfo))
+	for _, node := range systemInfo {
+		asked = append(asked, node)
 	}
 
-	nodeWaiting := len(WorkerNode.SystemInfo)
-
-	JobStatusWaitingGroup.Wait()
-
-	return nodeWaiting
+	return w.callJobStatus(ctx, asked)
 }
 
-func oneJobStatus(name string) int {
-
-	nodeWaiting := 0
-
-	for _, node := range WorkerNode.SystemInfo {
+func (w *Worker) oneJobStatus(ctx context.Context, name string) ([]message.Message, []node.NodeInfo) {
+	asked := make([]node.NodeInfo, 0)
+	for _, node := range w.table().SystemInfo {
 		if strings.EqualFold(name, node.JobName) {
-			msg := message.MakeJobStatusRequestMessage(*WorkerNode.GetNodeInfo(), node)
-			nextNode := findNextNode(node, msg.Route)
-			JobStatusWaitingGroup.Add(1)
-			sendMessage(WorkerNode.GetNodeInfo(), &nextNode, msg)
-			nodeWaiting++
-
+			asked = append(asked, node)
 		}
 	}
-	LogFileChan <- fmt.Sprintf("Waiting: %d", nodeWaiting)
-	JobStatusWaitingGroup.Wait()
+	w.LogFileChan <- fmt.Sprintf("Waiting: %d", len(asked))
 
-	return nodeWaiting
+	return w.callJobStatus(ctx, asked)
 }
 
-func oneNodeJobStatus(name, fractalID string) int {
-	for _, node := range WorkerNode.SystemInfo {
+func (w *Worker) oneNodeJobStatus(ctx context.Context, name, fractalID string) ([]message.Message, []node.NodeInfo) {
+	asked := make([]node.NodeInfo, 0, 1)
+	for _, node := range w.table().SystemInfo {
 		if strings.EqualFold(name, node.JobName) && strings.EqualFold(fractalID, node.FractalId) {
-			msg := message.MakeJobStatusRequestMessage(*WorkerNode.GetNodeInfo(), node)
-			nextNode := findNextNode(node, msg.Route)
-
-			JobStatusWaitingGroup.Add(1)
-			sendMessage(WorkerNode.GetNodeInfo(), &nextNode, msg)
+			asked = append(asked, node)
 			break
 		}
 	}
 
-	JobStatusWaitingGroup.Wait()
+	return w.callJobStatus(ctx, asked)
+}
+
+func (w *Worker) callJobStatus(ctx context.Context, asked []node.NodeInfo) ([]message.Message, []node.NodeInfo) {
+	return w.callNodes(ctx, asked, func(reciver node.NodeInfo) *message.Message {
+		return message.MakeJobStatusRequestMessage(*w.nodeInfo(), reciver)
+	})
+}
+
+func (w *Worker) parseStatusJob(args string) {
+	jobStatusMap, missing := w.collectJobStatus(w.ctx, args)
 
-	return 1
+	for _, jobstat := range jobStatusMap {
+		fmt.Println(jobstat.Report())
+	}
+	if len(missing) > 0 {
+		fmt.Printf("Status is partial, no answer in %v from: %s\n", w.QueryTimeout, describeMissing(missing))
+	}
 }
 
-func parseStatusJob(args string) {
-	LogFileChan <- "Status getting: " + args + " ))))"
+// collectJobStatus returns the status of the asked jobs and the nodes that
+// did not answer in time.
+func (w *Worker) collectJobStatus(ctx context.Context, args string) (map[string]job.JobStatus, []node.NodeInfo) {
+	w.LogFileChan <- "Status getting: " + args + " ))))"
 
 	args_array := strings.Split(args, " ")
 
-	nodeWaiting := 0
+	var responses []message.Message
+	var missing []node.NodeInfo
 
 	if len(args) == 0 {
-		LogFileChan <- "All jobs status"
-		nodeWaiting = allJobsStatus()
+		w.LogFileChan <- "All jobs status"
+		responses, missing = w.allJobsStatus(ctx)
 	} else {
 
 		switch len(args_array) {
 		case 1:
-			LogFileChan <- "One job status"
-			nodeWaiting = oneJobStatus(args_array[0])
+			w.LogFileChan <- "One job status"
+			responses, missing = w.oneJobStatus(ctx, args_array[0])
 		case 2:
-			LogFileChan <- "One job on one node status"
-			nodeWaiting = oneNodeJobStatus(args_array[0], args_array[1])
+			w.LogFileChan <- "One job on one node status"
+			responses, missing = w.oneNodeJobStatus(ctx, args_array[0], args_array[1])
 		default:
-			LogErrorChan <- "wrong number of arguments: " + args
+			w.LogErrorChan <- "wrong number of arguments: " + args
 		}
 	}
+
 	jobStatusMap := make(map[string]job.JobStatus)
 
-	for i := 0; i < nodeWaiting; i++ {
-		tmpJobStatus := <-JobStatusChannel
+	for _, response := range responses {
+		tmpJobStatus, _ := message.Payload[job.JobStatus](response)
 		if len(tmpJobStatus.Name) == 0 {
 			continue
 		}
@@ -1384,16 +1928,10 @@ func parseStatusJob(args string) {
 		}
 	}
 
-	for _, jobstat := range jobStatusMap {
-		fmt.Printf("Job %s with %d Working nodes has %d generated points\n", jobstat.Name, jobstat.WorkingNodes, jobstat.PointsGenerated)
-		for key, val := range jobstat.PointsPerNodes {
-			fmt.Printf("\t %s] %d\n", key, val)
-		}
-		fmt.Println("-----------------------------")
-	}
+	return jobStatusMap, missing
 }
 
-func parseCommand(commandArg string) bool {
+func (w *Worker) parseCommand(commandArg string) bool {
 
 	if len(commandArg) == 0 {
 		return true
@@ -1403,18 +1941,19 @@ func parseCommand(commandArg string) bool {
 	command := command_arr[0]
 	if strings.EqualFold(command, "quit") {
 		fmt.Println("Quitting...")
-		ListenPortListenChan <- 1
+		w.leaveSystem()
+		w.ListenPortListenChan <- 1
 
 		time.Sleep(time.Second)
 		return false
 	} else if strings.EqualFold(command, "start") {
-		parseStartJob(command_arr[1])
+		w.parseStartJob(w.ctx, command_arr[1])
 	} else if strings.EqualFold(command, "result") {
 		if len(command_arr) > 1 {
-			parseResultJob(command_arr[1])
+			w.parseResultJob(command_arr[1])
 		}
 	} else if strings.EqualFold(command, "stop") {
-		parseStopJob(command_arr[1])
+		w.parseStopJob(w.ctx, command_arr[1])
 	} else if strings.EqualFold(command, "status") {
 		var args string
 		if len(command_arr) == 1 {
@@ -1422,16 +1961,22 @@ func parseCommand(commandArg string) bool {
 		} else {
 			args = command_arr[1]
 		}
-		parseStatusJob(args)
+		w.parseStatusJob(args)
 	} else if strings.EqualFold(command, "list") {
-		parseListNodes()
+		w.parseListNodes()
+	} else if strings.EqualFold(command, "token") {
+		w.parseTokenHolder()
+	} else if strings.EqualFold(command, "snapshot") {
+		w.parseSnapshot()
+	} else if strings.EqualFold(command, "broadcasts") {
+		w.parseBroadcastCounts()
 	} else {
 		fmt.Printf("Unknown command: %s\n", command)
 	}
 	return true
 }
 
-func listenCommand(listenChan chan int32) {
+func (w *Worker) listenCommand(listenChan chan int32) {
 	fmt.Println("Simple Shell")
 	fmt.Println("---------------------")
 
@@ -1449,7 +1994,7 @@ func listenCommand(listenChan chan int32) {
 			if err != nil {
 				// close channel just to inform others
 				close(in)
-				LogErrorChan <- fmt.Sprintln("Error in read string", err)
+				w.LogErrorChan <- fmt.Sprintln("Error in read string", err)
 			}
 			text = strings.Replace(text, "\n", "", -1)
 			in <- text
@@ -1463,7 +2008,7 @@ func listenCommand(listenChan chan int32) {
 		case <-listenChan:
 			return
 		case text := <-input:
-			if !parseCommand(text) {
+			if !w.parseCommand(text) {
 				return
 			}
 			wainchanel <- " "
@@ -1471,46 +2016,48 @@ func listenCommand(listenChan chan int32) {
 	}
 }
 
-func findNextNode(goal node.NodeInfo, route []int) node.NodeInfo {
+func (w *Worker) findNextNode(goal node.NodeInfo, route []int) node.NodeInfo {
+	w.WorkerTableMutex.Lock()
+	defer w.WorkerTableMutex.Unlock()
 
-	if WorkerNode.Id == goal.Id || WorkerNode.Prev == goal.Id || WorkerNode.Next == goal.Id {
+	if w.WorkerNode.Id == goal.Id || w.WorkerNode.Prev == goal.Id || w.WorkerNode.Next == goal.Id {
 		return goal
 	}
 
-	if _, ok := WorkerNode.Connections[goal.FractalId]; ok {
+	if _, ok := w.WorkerNode.Connections[goal.FractalId]; ok {
 		return goal
 	}
 
 	var v, u, nextNode node.NodeInfo
 	var candInd1, candInd2 int
-	v, u = *WorkerNode.GetNodeInfo(), goal
-	candInd1, candInd2 = WorkerNode.Prev, WorkerNode.Next
+	v, u = *w.WorkerNode.GetNodeInfo(), goal
+	candInd1, candInd2 = w.WorkerNode.Prev, w.WorkerNode.Next
 
 	dist1 := (v.Id - u.Id)
 	if dist1 < 0 {
 		dist1 = structures.AbsoluteInt(dist1)
-		candInd1, candInd2 = WorkerNode.Next, WorkerNode.Prev
+		candInd1, candInd2 = w.WorkerNode.Next, w.WorkerNode.Prev
 	}
-	dist2 := len(WorkerNode.SystemInfo) - dist1
+	dist2 := len(w.WorkerNode.SystemInfo) - dist1
 	var minDist int
 	if dist1 > dist2 {
-		nextNode = WorkerNode.SystemInfo[candInd2]
+		nextNode = w.WorkerNode.SystemInfo[candInd2]
 		minDist = dist2
 	} else {
-		nextNode = WorkerNode.SystemInfo[candInd1]
+		nextNode = w.WorkerNode.SystemInfo[candInd1]
 		minDist = dist1
 	}
 
-	LogFileChan <- fmt.Sprintf("to NODE %d next node is %d (NEXT: %d(%d) vs. PREV: %d(%d))", goal.Id, nextNode.Id, candInd1, dist1, candInd2, dist2)
+	w.LogFileChan <- fmt.Sprintf("to NODE %d next node is %d (NEXT: %d(%d) vs. PREV: %d(%d))", goal.Id, nextNode.Id, candInd1, dist1, candInd2, dist2)
 
-	if len(WorkerNode.FractalId) == 0 || len(goal.FractalId) == 0 {
+	if len(w.WorkerNode.FractalId) == 0 || len(goal.FractalId) == 0 {
 		return nextNode
 	}
 
-	editDist := modulemath.EditDistance(WorkerNode.FractalId, goal.FractalId)
+	editDist := modulemath.EditDistance(w.WorkerNode.FractalId, goal.FractalId)
 
 	if minDist > editDist {
-		myArr := []rune(WorkerNode.FractalId)
+		myArr := []rune(w.WorkerNode.FractalId)
 		goalArr := []rune(goal.FractalId)
 
 		sze := len(myArr)
@@ -1524,7 +2071,7 @@ func findNextNode(goal node.NodeInfo, route []int) node.NodeInfo {
 			copy(tmpArr, myArr)
 			// fmt.Println("tmparr: ", tmpArr, myArr)
 			tmpArr[i] = goalArr[i]
-			if v, ok := WorkerNode.Connections[string(tmpArr)]; ok {
+			if v, ok := w.WorkerNode.Connections[string(tmpArr)]; ok {
 				nextNode = v
 				break
 			}